@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/file_manager"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
+)
+
+func runCompile(args []string) int {
+	set := newFlagSet("compile")
+	cfgFlags := addConfigFlags(set)
+	fmtFlags := addFormatFlags(set)
+	isMetatiles := set.Bool("mtiles", false, "treat images as metatile sheets")
+	dedup := set.String("dedup", "", "tile deduplication for metatile sheets: exact or flip")
+	name := set.String("name", "", "output file name, only used with a single image")
+	force := set.Bool("force", false, "compile images even if they didn't change")
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+
+	if set.NArg() > 1 && len(*name) != 0 {
+		fmt.Fprintln(os.Stderr, "-name requires a single image")
+		return exitUsage
+	}
+	if set.NArg() == 0 && len(cfgFlags.config) == 0 {
+		fmt.Fprintln(os.Stderr, "expected a config or images")
+		set.Usage()
+		return exitUsage
+	}
+
+	cfg, err := cfgFlags.load()
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	err = cfgFlags.apply(cfg)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	if len(cfg.Palette) == 0 {
+		fmt.Fprintln(os.Stderr, "palette is required to compile images")
+		return exitUsage
+	}
+
+	if set.NArg() != 0 {
+		entry := common.Compile{Name: *name, Format: cfg.MetatileFormat, TileFormat: cfg.TileFormat, Compression: cfg.Compression}
+		if *isMetatiles {
+			entry.Type = common.CompileMetatiles
+		}
+		if len(*dedup) != 0 {
+			entry.DetectFlips, err = serializer.ParseDedup(*dedup)
+			if err != nil {
+				printError(common.Wrap(err, "-dedup"))
+				return exitUsage
+			}
+		}
+
+		cfg.Compile = cfg.Compile[:0]
+		for _, image := range set.Args() {
+			entry.Image = image
+			cfg.Compile = append(cfg.Compile, entry)
+		}
+	}
+	for i := range cfg.Compile {
+		cfg.Compile[i].Format, err = fmtFlags.apply(cfg.Compile[i].Format)
+		if err == nil {
+			cfg.Compile[i].TileFormat, err = fmtFlags.applyTileFormat(cfg.Compile[i].TileFormat)
+		}
+		if err == nil {
+			cfg.Compile[i].Compression, err = fmtFlags.applyCompression(cfg.Compile[i].Compression)
+		}
+		if err != nil {
+			printError(err)
+			return exitUsage
+		}
+	}
+
+	err = createOutputDirs(cfg)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	b, err := newBuilds(cfg, *force)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	manager := file_manager.NewManager(cfg)
+	jobs := make([]job, 0, len(cfg.Compile))
+	for i := range cfg.Compile {
+		entry := cfg.Compile[i]
+		jobs = append(jobs, func(log io.Writer) bool {
+			_, err := b.build(manager, "compile:"+entry.Image+":"+entry.Name, entry, func(manager *file_manager.Manager) ([]string, error) {
+				return []string{entry.Image}, compile(cfg, manager.WithTileFormat(entry.TileFormat).WithCompression(entry.Compression).WithLog(log), entry, log)
+			})
+			return logResult(log, err)
+		})
+	}
+
+	ok := runJobs(cfg.Jobs, jobs)
+	if err := b.save(); err != nil {
+		printError(err)
+		ok = false
+	}
+	if !ok {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func compile(cfg *common.Config, manager *file_manager.Manager, entry common.Compile, log io.Writer) error {
+	img, err := tileset.LoadImage(common.HostFS, entry.Image)
+	if err != nil {
+		return common.Wrap(err, "failed to read image", entry.Image)
+	}
+
+	name := entry.Name
+	if len(name) == 0 {
+		name = outputName(entry.Image)
+	}
+
+	if entry.Type == common.CompileMetatiles {
+		return compileMetatiles(cfg, manager, img, name, entry, log)
+	}
+
+	tileData, err := tileset.ImageToTiles(img, cfg.Palette)
+	if err != nil {
+		return common.Wrap(err, "failed to convert image", entry.Image)
+	}
+
+	encoded, err := manager.WriteTileData(tileData, name)
+	if err != nil {
+		return common.Wrap(err, "failed to write tile data", entry.Image)
+	}
+
+	err = manager.ExportTileData(tileData, encoded, name)
+	if err != nil {
+		return common.Wrap(err, "failed to export tile data", entry.Image)
+	}
+
+	return nil
+}
+
+func compileMetatiles(cfg *common.Config, manager *file_manager.Manager, img image.Image, name string, entry common.Compile, log io.Writer) error {
+	imgPath := entry.Image
+	tileData, mtiles, err := tileset.ImageToMetatiles(img, cfg.Palette, entry.Format.Size, entry.DetectFlips)
+	if err != nil {
+		return common.Wrap(err, "failed to convert image", imgPath)
+	}
+
+	fmt.Fprintf(log, "%s: %d unique tiles in %d metatiles\n", imgPath, len(tileData.Data), len(mtiles.Metatiles))
+
+	// tile data is expected to be loaded at $8800 in signed mode
+	mtiles.Addressing = entry.Format.Addressing
+	for i := range mtiles.Metatiles {
+		for j, index := range mtiles.Metatiles[i].Tiles {
+			mtiles.Metatiles[i].Tiles[j] = mtiles.Addressing.RefIndex(index)
+		}
+	}
+
+	encoded, err := manager.WriteTileData(tileData, name)
+	if err != nil {
+		return common.Wrap(err, "failed to write tile data", imgPath)
+	}
+
+	err = manager.WriteMetatileData(mtiles, entry.Format, name)
+	if err != nil {
+		return common.Wrap(err, "failed to write metatile data", imgPath)
+	}
+
+	insertFileRef(&mtiles.Refs, manager.GetBinaryPath(name, manager.TileDataExtension(), true), tileData, 0)
+
+	err = manager.WriteMetatileJSON(mtiles, name)
+	if err != nil {
+		return common.Wrap(err, "failed to write json", imgPath)
+	}
+
+	err = manager.ExportTileData(tileData, encoded, name)
+	if err != nil {
+		return common.Wrap(err, "failed to export tile data", imgPath)
+	}
+	err = manager.ExportMetatileData(mtiles, entry.Format, name)
+	if err != nil {
+		return common.Wrap(err, "failed to export metatile data", imgPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestImage(t *testing.T, path string, img image.Image) {
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, img))
+	assert.NoError(t, file.Close())
+}
+
+func TestCompileMetatiles(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{0xff, 0xff, 0xff, 0xff},
+		color.RGBA{0xaa, 0xaa, 0xaa, 0xff},
+		color.RGBA{0x55, 0x55, 0x55, 0xff},
+		color.RGBA{0x00, 0x00, 0x00, 0xff},
+	}
+	// Two 3x1 metatiles: a blank tile, a dot and its X flip, then a tile of color 3, the dot and a blank tile
+	img := image.NewPaletted(image.Rect(0, 0, 6*common.TileSizePx, common.TileSizePx), palette)
+	img.SetColorIndex(common.TileSizePx, 0, 1)
+	img.SetColorIndex(3*common.TileSizePx-1, 0, 1)
+	for y := 0; y < common.TileSizePx; y++ {
+		for x := 3 * common.TileSizePx; x < 4*common.TileSizePx; x++ {
+			img.SetColorIndex(x, y, 3)
+		}
+	}
+	img.SetColorIndex(4*common.TileSizePx, 0, 1)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.png")
+	writeTestImage(t, file, img)
+	out := filepath.Join(dir, "out")
+	code := runCompile([]string{"-palette", testPaletteFlag, "-type", "2bpp", "-out", out, "-mtiles", "-dedup", "flip",
+		"-metatile-width", "3", "-metatile-height", "1", "-addressing", "8800", file})
+	assert.Equal(t, exitOK, code)
+
+	blank, dot, solid := make([]byte, 16), make([]byte, 16), bytes.Repeat([]byte{0xff}, 16)
+	dot[0] = 0x80
+	data, err := os.ReadFile(filepath.Join(out, "a.chr"))
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Join([][]byte{blank, dot, solid}, nil), data)
+	data, err = os.ReadFile(filepath.Join(out, "a.mtile"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x80, 0x81, 0x81, 0x82, 0x81, 0x80}, data)
+	data, err = os.ReadFile(filepath.Join(out, "a.attr"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, byte(common.FlipX), 0, 0, 0}, data)
+
+	// The width isn't a multiple of the metatile width
+	file = filepath.Join(dir, "b.png")
+	writeTestImage(t, file, image.NewPaletted(image.Rect(0, 0, 4*common.TileSizePx, common.TileSizePx), palette))
+	code = runCompile([]string{"-palette", testPaletteFlag, "-type", "2bpp", "-out", out, "-mtiles",
+		"-metatile-width", "3", "-metatile-height", "1", file})
+	assert.Equal(t, exitFailure, code)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/file_manager"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
+)
+
+func runConvert(args []string) int {
+	set := newFlagSet("convert")
+	cfgFlags := addConfigFlags(set)
+	force := set.Bool("force", false, "convert files even if their inputs didn't change")
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+
+	cfg, err := cfgFlags.load()
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	err = cfgFlags.apply(cfg)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	files := cfg.ConvertToPng
+	if set.NArg() != 0 {
+		files = set.Args()
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "expected files to convert")
+		set.Usage()
+		return exitUsage
+	}
+
+	err = createOutputDirs(cfg)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	b, err := newBuilds(cfg, *force)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	ok := runTargets(cfg.Jobs, convertTargets(cfg, file_manager.NewManager(cfg), b, files))
+	if err := b.save(); err != nil {
+		printError(err)
+		ok = false
+	}
+	if !ok {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// convertToPNG renders tile, metatile or map data, the kind of data is detected from the "type" field.
+// Tiled tilesets of tile and metatile data are written next to the images with the tiled output type.
+// Returns the converted file and the files it references
+func convertToPNG(cfg *common.Config, manager *file_manager.Manager, file string) ([]string, error) {
+	parsed, err := tileset.LoadJSON(common.HostFS, file, cfg.Strict)
+	if err != nil {
+		return nil, err
+	}
+	inputs := []string{file}
+
+	var img *image.Paletted
+	var writeTSX func() error
+	isTileData := false
+	switch parsed := parsed.(type) {
+	case *common.Tiles:
+		if len(parsed.Palette) == 0 {
+			parsed.Palette = cfg.Palette
+		}
+		img, err = tileset.RenderTiles(parsed)
+		isTileData = true
+		writeTSX = func() error { return manager.WriteTilesTSX(parsed, outputName(file), file) }
+	case *common.Metatiles:
+		if len(parsed.Palette) == 0 {
+			parsed.Palette = cfg.Palette
+		}
+		img, err = manager.MetatileToImage(parsed)
+		inputs = append(inputs, refInputs(parsed.Refs)...)
+		writeTSX = func() error { return manager.WriteMetatileTSX(parsed, outputName(file), file) }
+	case *common.TileMap:
+		for _, file := range []string{parsed.MetatileFile, parsed.CellFile, parsed.AttributeFile} {
+			if len(file) != 0 {
+				inputs = append(inputs, file)
+			}
+		}
+		if parsed.Metatiles != nil {
+			inputs = append(inputs, refInputs(parsed.Metatiles.Refs)...)
+		}
+		inputs = append(inputs, refInputs(parsed.Refs)...)
+		if len(parsed.Palette) == 0 && (parsed.Metatiles == nil || len(parsed.Metatiles.Palette) == 0) {
+			parsed.Palette = cfg.Palette
+		}
+		img, err = manager.MapToImage(parsed)
+	}
+	if err != nil {
+		return inputs, common.Wrap(err, "failed to render", file)
+	}
+
+	err = manager.WritePNG(img, outputName(file), isTileData)
+	if err == nil && writeTSX != nil && manager.OutputType().Has(common.OutputTiled) {
+		err = writeTSX()
+	}
+	if err != nil {
+		return inputs, common.Wrap(err, "failed to convert", file)
+	}
+	return inputs, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+)
+
+func runDiff(args []string) int {
+	set := newFlagSet("diff")
+	cfgFlags := addConfigFlags(set)
+	fmtFlags := addFormatFlags(set)
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+	if set.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "expected two files")
+		set.Usage()
+		return exitUsage
+	}
+
+	cfg, err := cfgFlags.load()
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	err = cfgFlags.apply(cfg)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	format, err := fmtFlags.apply(cfg.MetatileFormat)
+	if err == nil {
+		cfg.TileFormat, err = fmtFlags.applyTileFormat(cfg.TileFormat)
+	}
+	if err == nil {
+		cfg.Compression, err = fmtFlags.applyCompression(cfg.Compression)
+	}
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+
+	lhsFile, rhsFile := set.Arg(0), set.Arg(1)
+	lhs, err := loadFile(cfg, format, lhsFile)
+	if err != nil {
+		printError(err, lhsFile)
+		return exitUsage
+	}
+	rhs, err := loadFile(cfg, format, rhsFile)
+	if err != nil {
+		printError(err, rhsFile)
+		return exitUsage
+	}
+
+	var differences []string
+	switch lhs := lhs.(type) {
+	case *common.Tiles:
+		rhs, ok := rhs.(*common.Tiles)
+		if !ok {
+			break
+		}
+		differences = diffTiles(lhs, rhs)
+	case *common.Metatiles:
+		rhs, ok := rhs.(*common.Metatiles)
+		if !ok {
+			break
+		}
+		differences = diffMetatiles(lhs, rhs)
+	default:
+		fmt.Fprintln(os.Stderr, "only tile data and metatile data can be compared")
+		return exitUsage
+	}
+	if differences == nil {
+		fmt.Fprintf(os.Stderr, "can't compare %s with %s: different kinds of data\n", lhsFile, rhsFile)
+		return exitUsage
+	}
+
+	for _, diff := range differences {
+		fmt.Println(diff)
+	}
+	if len(differences) != 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// The result is empty if the data is the same
+func diffTiles(lhs, rhs *common.Tiles) []string {
+	result := []string{}
+	if len(lhs.Data) != len(rhs.Data) {
+		result = append(result, fmt.Sprintf("tile count: %d != %d", len(lhs.Data), len(rhs.Data)))
+	}
+	for i := 0; i < len(lhs.Data) && i < len(rhs.Data); i++ {
+		if !bytes.Equal(lhs.Data[i], rhs.Data[i]) {
+			result = append(result, fmt.Sprintf("tile %02x differs", i))
+		}
+	}
+	return result
+}
+
+// The result is empty if the data is the same
+func diffMetatiles(lhs, rhs *common.Metatiles) []string {
+	result := []string{}
+	if lhs.Size != rhs.Size {
+		result = append(result, fmt.Sprintf("metatile size: %dx%d != %dx%d", lhs.Size.Width, lhs.Size.Height, rhs.Size.Width, rhs.Size.Height))
+	}
+	if len(lhs.Metatiles) != len(rhs.Metatiles) {
+		result = append(result, fmt.Sprintf("metatile count: %d != %d", len(lhs.Metatiles), len(rhs.Metatiles)))
+	}
+	for i := 0; i < len(lhs.Metatiles) && i < len(rhs.Metatiles); i++ {
+		lhsTile, rhsTile := lhs.Metatiles[i], rhs.Metatiles[i]
+		if !bytes.Equal(lhsTile.Tiles, rhsTile.Tiles) {
+			result = append(result, fmt.Sprintf("metatile %d: tiles %x != %x", i, lhsTile.Tiles, rhsTile.Tiles))
+		}
+		for j := range lhsTile.Tiles {
+			if lhsTile.GetAttributes(j) != rhsTile.GetAttributes(j) {
+				result = append(result, fmt.Sprintf("metatile %d: attributes differ", i))
+				break
+			}
+		}
+	}
+	return result
+}
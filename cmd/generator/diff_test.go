@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, data, 0666))
+		return path
+	}
+	a := file("a.chr", make([]byte, 32))
+	same := file("same.chr", make([]byte, 32))
+	other := file("other.chr", append([]byte{0xff}, make([]byte, 15)...))
+	mtiles := file("m.mtile.json", []byte(`{"type": "mtiles", "metatiles": [{"tl": "0", "tr": "0", "bl": "0", "br": "0"}]}`))
+	palette := []string{"-palette", testPaletteFlag}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		output string
+	}{
+		{"same", append(palette, a, same), exitOK, ""},
+		{"different", append(palette, a, other), exitFailure, "tile count: 2 != 1\ntile 00 differs\n"},
+		{"one file", append(palette, a), exitUsage, ""},
+		{"missing file", append(palette, a, filepath.Join(dir, "missing.chr")), exitUsage, ""},
+		{"different kinds", append(palette, a, mtiles), exitUsage, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var code int
+			output := captureStdout(t, func() { code = runDiff(test.args) })
+			assert.Equal(t, test.code, code)
+			assert.Equal(t, test.output, output)
+		})
+	}
+}
+
+func TestInfo(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.chr")
+	assert.NoError(t, os.WriteFile(file, make([]byte, 48), 0666))
+
+	var code int
+	output := captureStdout(t, func() { code = runInfo([]string{"-palette", testPaletteFlag, file}) })
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, output, file+": tile data, 3 tiles")
+
+	assert.Equal(t, exitFailure, runInfo([]string{file, filepath.Join(dir, "missing.chr")}))
+	assert.Equal(t, exitUsage, runInfo(nil))
+}
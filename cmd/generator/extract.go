@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/extractor"
+	"github.com/Onlymiind/tileset_manager/internal/file_manager"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
+)
+
+func runExtract(args []string) int {
+	set := newFlagSet("extract")
+	cfgFlags := addConfigFlags(set)
+	fmtFlags := addFormatFlags(set)
+	metatilePath := set.String("metatiles", "", "metatile data file, only used with a single tile data file")
+	attributePath := set.String("attributes", "", "CGB attribute file, only used with a single tile data file")
+	bank1Path := set.String("bank1", "", "tile data in VRAM bank 1, only used with a single tile data file")
+	name := set.String("name", "", "output file name, only used with a single tile data file")
+	dedup := set.String("dedup", "", "write only unique tiles and rewrite the -metatiles: exact or flip")
+	force := set.Bool("force", false, "rebuild entries even if their inputs didn't change")
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+
+	files := set.Args()
+	if len(files) != 1 && (len(*metatilePath) != 0 || len(*attributePath) != 0 || len(*bank1Path) != 0 || len(*name) != 0) {
+		fmt.Fprintln(os.Stderr, "-metatiles, -attributes, -bank1 and -name require a single tile data file")
+		return exitUsage
+	}
+	if len(*dedup) != 0 && len(*metatilePath) == 0 {
+		fmt.Fprintln(os.Stderr, "-dedup requires -metatiles")
+		return exitUsage
+	}
+	if len(files) == 0 && len(cfgFlags.config) == 0 {
+		fmt.Fprintln(os.Stderr, "expected a config or tile data files")
+		set.Usage()
+		return exitUsage
+	}
+
+	cfg, err := cfgFlags.load()
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	err = cfgFlags.apply(cfg)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	cfg.MetatileFormat, err = fmtFlags.apply(cfg.MetatileFormat)
+	if err == nil {
+		cfg.TileFormat, err = fmtFlags.applyTileFormat(cfg.TileFormat)
+	}
+	if err == nil {
+		cfg.Compression, err = fmtFlags.applyCompression(cfg.Compression)
+	}
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	detectFlips := false
+	if len(*dedup) != 0 {
+		detectFlips, err = serializer.ParseDedup(*dedup)
+		if err != nil {
+			printError(common.Wrap(err, "-dedup"))
+			return exitUsage
+		}
+	}
+	err = createOutputDirs(cfg)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	b, err := newBuilds(cfg, *force)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	manager := file_manager.NewManager(cfg)
+	if len(files) != 0 {
+		targets := make([]*target, 0, len(files))
+		for _, file := range files {
+			entry := common.Manual{
+				TileData:      file,
+				MetatileData:  *metatilePath,
+				AttributeData: *attributePath,
+				Bank1TileData: *bank1Path,
+				Name:          *name,
+				Format:        cfg.MetatileFormat,
+				TileFormat:    cfg.TileFormat,
+				Compression:   cfg.Compression,
+				Dedup:         len(*dedup) != 0,
+				DetectFlips:   detectFlips,
+			}
+			if len(entry.Name) == 0 {
+				entry.Name = outputName(file)
+			}
+			targets = append(targets, fileTarget(cfg, manager, b, entry))
+		}
+		ok := runTargets(cfg.Jobs, targets)
+		if err := b.save(); err != nil {
+			printError(err)
+			ok = false
+		}
+		if !ok {
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	// The tree is walked first, so that the files are processed and reported in lexical order
+	auto, err := autoTargets(cfg, manager, b)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	failed := !runTargets(cfg.Jobs, auto)
+	failed = !runTargets(cfg.Jobs, manualTargets(cfg, manager, b)) || failed
+	failed = !runTargets(cfg.Jobs, convertTargets(cfg, manager, b, cfg.ConvertToPng)) || failed
+	printCacheStats(manager)
+
+	if err := b.save(); err != nil {
+		printError(err)
+		failed = true
+	}
+	if failed {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// insertFileRef maps indexes starting from 0 to all tiles of the file, refs must not contain the bank
+func insertFileRef(refs *common.TileRefs, file string, tiles *common.Tiles, bank uint8) {
+	count := len(tiles.Data)
+	if count == 0 {
+		return
+	}
+	if count > common.MaxTilesPerFile {
+		count = common.MaxTilesPerFile
+	}
+	refs.Insert(common.TileRef{
+		File:  file,
+		Range: common.IndexRange{Start: 0, End: uint8(count - 1)},
+		Bank:  bank,
+	})
+}
+
+func printCacheStats(manager *file_manager.Manager) {
+	stats := manager.CacheStats()
+	fmt.Fprintf(os.Stderr, "tile cache: %d hits, %d misses, %d evictions, %d stale, %.2f of %.2f kb used\n",
+		stats.Hits, stats.Misses, stats.Evictions, stats.Stale, stats.Size.As(common.Kilobytes), stats.MaxSize.As(common.Kilobytes))
+}
+
+func process(cfg *common.Config, manager *file_manager.Manager, entry common.Manual, writeTileData bool) error {
+	tilePath, metatilePath, name := entry.TileData, entry.MetatileData, entry.Name
+	manager = manager.WithTileFormat(entry.TileFormat).WithCompression(entry.Compression)
+	tileData, err := tileset.LoadTiles(common.HostFS, tilePath, manager.TileOptions())
+	if err != nil {
+		return common.Wrap(err, "failed to extract tile data", tilePath)
+	}
+	compression := common.CompressionOf(tilePath, entry.Compression)
+	if info, err := os.Stat(tilePath); err == nil && compression != common.CompressionNone {
+		raw := len(tileData.Data) * extractor.CodecFor(common.TileFormatOf(tilePath, entry.TileFormat)).BytesPerTile()
+		manager.ReportCompression(tilePath, compression, raw, int(info.Size()))
+	}
+	tileData.Palette = cfg.Palette
+	// Tile data is exported in the format it was read in
+	exporter := manager.WithTileFormat(common.TileFormatOf(tilePath, entry.TileFormat)).WithCompression(compression)
+	tileFile := tilePath
+	// Tile data rewritten by deduplication, exported without encoding it again
+	var encoded *file_manager.EncodedTiles
+
+	var mtiles *common.Metatiles
+	if len(metatilePath) != 0 {
+		refs, err := entryRefs(cfg, manager, entry, tilePath, tileData)
+		if err != nil {
+			return err
+		}
+		mtiles, err = tileset.LoadMetatiles(common.HostFS, metatilePath, entry.AttributeData, refs, entry.Format)
+		if err != nil {
+			return common.Wrap(err, "failed to extract metatile data")
+		}
+		if len(mtiles.Palette) == 0 {
+			mtiles.Palette = cfg.Palette
+		}
+		if len(mtiles.CGBPalettes) == 0 {
+			mtiles.CGBPalettes = cfg.CGBPalettes
+		}
+
+		if entry.Dedup {
+			tileData, err = dedupTiles(cfg, tileData, mtiles, entry.DetectFlips)
+			if err != nil {
+				return common.Wrap(err, "failed to deduplicate tiles", tilePath)
+			}
+			// The metatiles reference the compacted tile data instead of the source file
+			encoded, err = exporter.WriteTileData(tileData, name)
+			if err != nil {
+				return common.Wrap(err, "failed to write tile data", tilePath)
+			}
+			tileFile = exporter.GetBinaryPath(name, exporter.TileDataExtension(), true)
+			mtiles.Refs, err = entryRefs(cfg, manager, entry, tileFile, tileData)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if writeTileData {
+		// Color indexes outside of the palette would make invalid JSON and images
+		if manager.OutputType().Has(common.OutputJSON | common.OutputPNG | common.OutputTiled) {
+			if err = tileset.CheckColors(tileData); err != nil {
+				return common.Wrap(err, "tile data doesn't match the palette", tilePath)
+			}
+		}
+
+		if manager.OutputType().Has(common.OutputJSON) {
+			err = manager.WriteTileJSON(tileData, name)
+			if err != nil {
+				return common.Wrap(err, "failed to write json", tilePath)
+			}
+		}
+
+		// Tiled tilesets need the image
+		if manager.OutputType().Has(common.OutputPNG | common.OutputTiled) {
+			png, err := tileset.RenderTiles(tileData)
+			if err == nil {
+				err = manager.WritePNG(png, name, true)
+			}
+			if err != nil {
+				return common.Wrap(err, "failed to write png", tilePath)
+			}
+		}
+
+		if manager.OutputType().Has(common.OutputTiled) {
+			err = manager.WriteTilesTSX(tileData, name, tileFile)
+			if err != nil {
+				return common.Wrap(err, "failed to write tsx", tilePath)
+			}
+		}
+
+		err = exporter.ExportTileData(tileData, encoded, name)
+		if err != nil {
+			return common.Wrap(err, "failed to export tile data", tilePath)
+		}
+	}
+	if mtiles != nil {
+		// Metatiles are rendered first, so that tiles which don't match the palette fail the entry before anything is written
+		var png *image.Paletted
+		if manager.OutputType().Has(common.OutputPNG | common.OutputTiled) {
+			png, err = manager.MetatileToImage(mtiles)
+			if err != nil {
+				return common.Wrap(err, "failed to render metatiles", tilePath)
+			}
+		}
+
+		// Tiled tilesets need the image and the metatiles to import maps
+		if manager.OutputType().Has(common.OutputJSON | common.OutputTiled) {
+			err = manager.WriteMetatileJSON(mtiles, name)
+			if err != nil {
+				return common.Wrap(err, "failed to write json", tilePath)
+			}
+		}
+
+		if png != nil {
+			err = manager.WritePNG(png, name, false)
+			if err != nil {
+				return common.Wrap(err, "failed to write png", tilePath)
+			}
+		}
+
+		if manager.OutputType().Has(common.OutputTiled) {
+			err = manager.WriteMetatileTSX(mtiles, name, manager.MetatileJSONPath(name))
+			if err != nil {
+				return common.Wrap(err, "failed to write tsx", tilePath)
+			}
+		}
+
+		err = manager.ExportMetatileData(mtiles, entry.Format, name)
+		if err != nil {
+			return common.Wrap(err, "failed to export metatile data", metatilePath)
+		}
+	}
+
+	return nil
+}
+
+// entryRefs maps the metatile indexes of the entry to the tile data file, bank 1 tile data and the empty tile
+func entryRefs(cfg *common.Config, manager *file_manager.Manager, entry common.Manual, tilePath string, tileData *common.Tiles) (common.TileRefs, error) {
+	refs := common.NewTileRefs()
+	insertFileRef(&refs, tilePath, tileData, 0)
+	if len(entry.Bank1TileData) != 0 {
+		bank1Data, err := tileset.LoadTiles(common.HostFS, entry.Bank1TileData, manager.TileOptions())
+		if err != nil {
+			return refs, common.Wrap(err, "failed to extract tile data", entry.Bank1TileData)
+		}
+		insertFileRef(&refs, entry.Bank1TileData, bank1Data, 1)
+	}
+	if len(cfg.EmptyTile.File) != 0 {
+		// The empty tile takes precedence over the tile data
+		err := refs.Override(cfg.EmptyTile)
+		if err != nil {
+			return refs, common.Wrap(err, "invalid empty tile")
+		}
+	}
+	return refs, nil
+}
+
+// dedupTiles returns unique tiles of tileData and rewrites the metatiles to use them, flips become CGB attributes.
+// Indexes of the empty tile are kept, so the unique tiles must not reach them
+func dedupTiles(cfg *common.Config, tileData *common.Tiles, mtiles *common.Metatiles, detectFlips bool) (*common.Tiles, error) {
+	result, remap := extractor.DeduplicateTiles(tileData, detectFlips)
+	if empty := cfg.EmptyTile; len(empty.File) != 0 && empty.Bank == 0 {
+		if len(result.Data) > int(empty.Range.Start) {
+			return nil, fmt.Errorf("%d unique tiles overlap the empty tile at index %02x", len(result.Data), empty.Range.Start)
+		}
+		for i := int(empty.Range.Start); i <= int(empty.Range.End) && i < len(remap); i++ {
+			remap[i] = common.TileRemap{Index: i}
+		}
+	}
+	return result, extractor.RemapMetatiles(mtiles.Metatiles, remap, mtiles.Addressing)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPaletteFlag = "ffffff,aaaaaa,555555,000000"
+
+// captureStdout returns everything written to stdout by f
+func captureStdout(t *testing.T, f func()) string {
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	assert.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = stdout }()
+	f()
+	assert.NoError(t, file.Close())
+	data, err := os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestExtractExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.chr")
+	assert.NoError(t, os.WriteFile(file, make([]byte, 32), 0666))
+	out := filepath.Join(dir, "out")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"ok", []string{"-palette", testPaletteFlag, "-out", out, file}, exitOK},
+		{"help", []string{"-help"}, exitOK},
+		{"no files", nil, exitUsage},
+		{"unknown flag", []string{"-bogus", file}, exitUsage},
+		{"invalid layout", []string{"-layout", "diagonal", "-out", out, file}, exitUsage},
+		{"invalid palette", []string{"-palette", "zzz", "-out", out, file}, exitUsage},
+		{"invalid type", []string{"-type", "raw", "-out", out, file}, exitUsage},
+		{"-name with two files", []string{"-name", "b", file, file}, exitUsage},
+		{"missing file", []string{"-palette", testPaletteFlag, "-out", out, filepath.Join(dir, "missing.chr")}, exitFailure},
+		{"missing config", []string{"-config", filepath.Join(dir, "missing.json")}, exitFailure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.code, runExtract(test.args))
+		})
+	}
+}
+
+// Stdin is only read once per process, so this is the only test reading it
+func TestExtractStdStreams(t *testing.T) {
+	stdin, err := os.Create(filepath.Join(t.TempDir(), "stdin"))
+	assert.NoError(t, err)
+	_, err = stdin.Write(make([]byte, 32))
+	assert.NoError(t, err)
+	_, err = stdin.Seek(0, 0)
+	assert.NoError(t, err)
+	defer stdin.Close()
+	os.Stdin, stdin = stdin, os.Stdin
+	defer func() { os.Stdin = stdin }()
+
+	var code int
+	output := captureStdout(t, func() {
+		code = runExtract([]string{"-palette", testPaletteFlag, "-type", "json", "-out", "-", "-"})
+	})
+	assert.Equal(t, exitOK, code)
+	tiles, err := serializer.ParseTileDataBytes("", []byte(output), true)
+	assert.NoError(t, err)
+	assert.Len(t, tiles.Data, 2)
+}
+
+func TestExtractPaletteTooSmall(t *testing.T) {
+	dir := t.TempDir()
+	// Plane 2 of the first row makes color index 4, which is outside of a 2bpp palette
+	tile := make([]byte, 32)
+	tile[16] = 0x80
+	file := filepath.Join(dir, "a.snes4.chr")
+	assert.NoError(t, os.WriteFile(file, tile, 0666))
+
+	out := filepath.Join(dir, "out")
+	code := runExtract([]string{"-palette", "ffffff,aaaaaa,555555,000000", "-type", "png,json", "-out", out, file})
+	assert.Equal(t, exitFailure, code)
+	assert.NoFileExists(t, filepath.Join(out, "a.tile.json"))
+	assert.NoFileExists(t, filepath.Join(out, "a.png"))
+
+	palette := "ffffff,eeeeee,dddddd,cccccc,bbbbbb,aaaaaa,999999,888888,777777,666666,555555,444444,333333,222222,111111,000000"
+	code = runExtract([]string{"-palette", palette, "-type", "json", "-out", out, file})
+	assert.Equal(t, exitOK, code)
+	assert.FileExists(t, filepath.Join(out, "a.tile.json"))
+}
+
+func TestExtractDedup(t *testing.T) {
+	dir := t.TempDir()
+	a := bytes.Repeat([]byte{0xf0, 0x0f}, 8)
+	b := []byte{0x80, 0, 0x40, 0, 0x20, 0, 0x10, 0, 8, 0, 4, 0, 2, 0, 1, 0}
+	bFlipped := []byte{1, 0, 2, 0, 4, 0, 8, 0, 0x10, 0, 0x20, 0, 0x40, 0, 0x80, 0}
+	file := filepath.Join(dir, "a.chr")
+	assert.NoError(t, os.WriteFile(file, bytes.Join([][]byte{a, b, a, bFlipped}, nil), 0666))
+	mtile := filepath.Join(dir, "a.mtile")
+	assert.NoError(t, os.WriteFile(mtile, []byte{0, 1, 2, 3}, 0666))
+
+	out := filepath.Join(dir, "out")
+	code := runExtract([]string{"-type", "2bpp", "-out", out, "-dedup", "flip", "-metatiles", mtile, file})
+	assert.Equal(t, exitOK, code)
+	data, err := os.ReadFile(filepath.Join(out, "a.chr"))
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Join([][]byte{a, b}, nil), data)
+	data, err = os.ReadFile(filepath.Join(out, "a.mtile"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 0, 1}, data)
+	data, err = os.ReadFile(filepath.Join(out, "a.attr"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, byte(common.FlipX)}, data)
+
+	assert.Equal(t, exitUsage, runExtract([]string{"-dedup", "flip", file}))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+)
+
+// configFlags override fields of the config, the config itself is optional
+type configFlags struct {
+	config     string
+	palette    string
+	out        string
+	outputType string
+	cacheSize  int
+	jobs       int
+	bitColors  string
+}
+
+func addConfigFlags(set *flag.FlagSet) *configFlags {
+	f := &configFlags{}
+	set.StringVar(&f.config, "config", "", "path to the config file")
+	set.StringVar(&f.palette, "palette", "", "comma-separated palette colors, e.g. ffffff,aaaaaa,555555,000000")
+	set.StringVar(&f.out, "out", "", "output directory, - writes the output to stdout")
+	set.StringVar(&f.outputType, "type", "", "comma-separated output types: png, json, asm, c, 2bpp, tiled")
+	set.IntVar(&f.cacheSize, "cache-size", 0, "tile cache size in kilobytes")
+	set.IntVar(&f.jobs, "jobs", 0, "number of files processed in parallel, defaults to the number of CPUs")
+	set.StringVar(&f.bitColors, "1bpp-colors", "", "color indexes of the 0 and 1 bits of 1bpp tiles, e.g. 0,3")
+	return f
+}
+
+// load parses the config if it is set, without a config the defaults are used. The overrides are applied by apply
+func (f *configFlags) load() (*common.Config, error) {
+	if len(f.config) != 0 {
+		return serializer.ParseConfig(f.config)
+	}
+	return serializer.ParseConfigBytes("", []byte("{}"))
+}
+
+// apply overrides fields of the config, errors are invalid flag values
+func (f *configFlags) apply(cfg *common.Config) error {
+	var err error
+	if len(f.palette) != 0 {
+		cfg.Palette, err = serializer.ParsePalette(f.palette)
+		if err != nil {
+			return common.Wrap(err, "-palette")
+		}
+	}
+	if len(f.out) != 0 {
+		cfg.Output.Directory = f.out
+	}
+	if len(f.outputType) != 0 {
+		cfg.Output.Type, err = serializer.ParseOutputType(f.outputType)
+		if err != nil {
+			return common.Wrap(err, "-type")
+		}
+	}
+	if f.cacheSize > 0 {
+		cfg.CacheSize = common.MemorySizeFrom(float64(f.cacheSize), common.Kilobytes)
+	}
+	if f.jobs > 0 {
+		cfg.Jobs = f.jobs
+	}
+	if len(f.bitColors) != 0 {
+		cfg.BitColors, err = serializer.ParseBitColors(f.bitColors)
+		if err != nil {
+			return common.Wrap(err, "-1bpp-colors")
+		}
+	}
+	// Files written to stdout by parallel jobs would come out in any order or interleaved
+	if cfg.Output.IsStdout() {
+		cfg.Jobs = 1
+	}
+	return nil
+}
+
+// formatFlags override the metatile and tile formats
+type formatFlags struct {
+	width       int
+	height      int
+	layout      string
+	addressing  string
+	tileFormat  string
+	compression string
+}
+
+func addFormatFlags(set *flag.FlagSet) *formatFlags {
+	f := &formatFlags{}
+	set.IntVar(&f.width, "metatile-width", 0, "metatile width in tiles")
+	set.IntVar(&f.height, "metatile-height", 0, "metatile height in tiles")
+	set.StringVar(&f.layout, "layout", "", "metatile data layout: row_major, column_major or planar")
+	set.StringVar(&f.addressing, "addressing", "", "tile addressing mode: 8000 or 8800")
+	set.StringVar(&f.tileFormat, "tile-format", "", "binary tile data format: gb, nes, snes4, pce, snes8, gba4, gba8 or 1bpp, overridden by extensions like .nes.chr")
+	set.StringVar(&f.compression, "compression", "", "compression of binary tile data: none, rle, pb16 or lzss, overridden by extensions like .chr.pb16")
+	return f
+}
+
+func (f *formatFlags) apply(format common.MetatileFormat) (common.MetatileFormat, error) {
+	var err error
+	if f.width > 0 {
+		format.Size.Width = f.width
+	}
+	if f.height > 0 {
+		format.Size.Height = f.height
+	}
+	if len(f.layout) != 0 {
+		format.Layout, err = serializer.ParseLayout(f.layout)
+		if err != nil {
+			return format, common.Wrap(err, "-layout")
+		}
+	}
+	if len(f.addressing) != 0 {
+		format.Addressing, err = serializer.ParseAddressing(f.addressing)
+		if err != nil {
+			return format, common.Wrap(err, "-addressing")
+		}
+	}
+	return format, nil
+}
+
+func (f *formatFlags) applyTileFormat(format common.TileFormat) (common.TileFormat, error) {
+	if len(f.tileFormat) == 0 {
+		return format, nil
+	}
+	format, err := serializer.ParseTileFormat(f.tileFormat)
+	if err != nil {
+		return format, common.Wrap(err, "-tile-format")
+	}
+	return format, nil
+}
+
+func (f *formatFlags) applyCompression(compression common.Compression) (common.Compression, error) {
+	if len(f.compression) == 0 {
+		return compression, nil
+	}
+	compression, err := serializer.ParseCompression(f.compression)
+	if err != nil {
+		return compression, common.Wrap(err, "-compression")
+	}
+	return compression, nil
+}
+
+func createOutputDirs(cfg *common.Config) error {
+	if cfg.Output.IsStdout() {
+		return nil
+	}
+
+	outDirs := []string{
+		cfg.Output.GetOutputPath(false, false),
+		cfg.Output.GetOutputPath(true, false),
+		cfg.Output.GetOutputPath(false, true),
+		cfg.Output.GetOutputPath(true, true),
+		cfg.Output.GetBinaryPath(false),
+		cfg.Output.GetBinaryPath(true),
+	}
+
+	for i := range outDirs {
+		if len(outDirs[i]) == 0 {
+			continue
+		}
+		err := os.MkdirAll(outDirs[i], 0777)
+		if err != nil {
+			return common.Wrap(err, "could not create output directory", outDirs[i])
+		}
+	}
+	return nil
+}
+
+// outputName is the file name without extensions
+func outputName(filePath string) string {
+	if filePath == common.StdStream {
+		return "stdin"
+	}
+	name := path.Base(filePath)
+	for ext := path.Ext(name); len(ext) != 0; ext = path.Ext(name) {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `{
+	"palette": ["ffffff", "aaaaaa", "555555", "000000"],
+	"output": {"directory": "build", "type": ["png"]},
+	"cache_size": 10,
+	"jobs": 2,
+	"metatile_width": 1,
+	"layout": "planar",
+	"tile_format": "nes",
+	"compression": "rle"
+}`
+
+func writeTestConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(testConfig), 0666))
+	return path
+}
+
+func TestConfigFlags(t *testing.T) {
+	config := writeTestConfig(t)
+	tests := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, cfg *common.Config)
+	}{
+		{"config", nil, func(t *testing.T, cfg *common.Config) {
+			assert.Len(t, cfg.Palette, 4)
+			assert.Equal(t, "build", cfg.Output.Directory)
+			assert.Equal(t, common.OutputPNG, cfg.Output.Type)
+			assert.Equal(t, common.MemorySizeFrom(10, common.Kilobytes), cfg.CacheSize)
+			assert.Equal(t, 2, cfg.Jobs)
+		}},
+		{"palette", []string{"-palette", "000000,ffffff"}, func(t *testing.T, cfg *common.Config) {
+			assert.Equal(t, []color.Color{color.RGBA{0, 0, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}}, cfg.Palette)
+		}},
+		{"out", []string{"-out", "other"}, func(t *testing.T, cfg *common.Config) {
+			assert.Equal(t, "other", cfg.Output.Directory)
+			assert.Equal(t, 2, cfg.Jobs)
+		}},
+		{"stdout", []string{"-out", "-"}, func(t *testing.T, cfg *common.Config) {
+			assert.True(t, cfg.Output.IsStdout())
+			// Files written to stdout by parallel jobs would be interleaved
+			assert.Equal(t, 1, cfg.Jobs)
+		}},
+		{"type", []string{"-type", "json,asm"}, func(t *testing.T, cfg *common.Config) {
+			assert.Equal(t, common.OutputJSON|common.OutputASM, cfg.Output.Type)
+		}},
+		{"cache size", []string{"-cache-size", "64"}, func(t *testing.T, cfg *common.Config) {
+			assert.Equal(t, common.MemorySizeFrom(64, common.Kilobytes), cfg.CacheSize)
+		}},
+		{"jobs", []string{"-jobs", "3"}, func(t *testing.T, cfg *common.Config) {
+			assert.Equal(t, 3, cfg.Jobs)
+		}},
+		{"1bpp colors", []string{"-1bpp-colors", "1,2"}, func(t *testing.T, cfg *common.Config) {
+			assert.Equal(t, common.BitColors{1, 2}, cfg.BitColors)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set := newFlagSet("test")
+			f := addConfigFlags(set)
+			assert.NoError(t, set.Parse(append([]string{"-config", config}, test.args...)))
+			cfg, err := f.load()
+			assert.NoError(t, err)
+			assert.NoError(t, f.apply(cfg))
+			test.check(t, cfg)
+		})
+	}
+
+	// Without a config the defaults are used
+	cfg, err := (&configFlags{}).load()
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.Palette)
+	assert.Equal(t, common.TileFormatGB, cfg.TileFormat)
+
+	for _, args := range [][]string{{"-palette", "fffffg"}, {"-type", "bmp"}, {"-1bpp-colors", "1"}} {
+		set := newFlagSet("test")
+		f := addConfigFlags(set)
+		assert.NoError(t, set.Parse(args))
+		cfg, err := f.load()
+		assert.NoError(t, err)
+		assert.Error(t, f.apply(cfg), args)
+	}
+	_, err = (&configFlags{config: "missing.json"}).load()
+	assert.Error(t, err)
+}
+
+func TestFormatFlags(t *testing.T) {
+	cfg, err := (&configFlags{config: writeTestConfig(t)}).load()
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, format common.MetatileFormat, tileFormat common.TileFormat, compression common.Compression)
+	}{
+		{"config", nil, func(t *testing.T, format common.MetatileFormat, tileFormat common.TileFormat, compression common.Compression) {
+			assert.Equal(t, common.MetatileSize{Width: 1, Height: 2}, format.Size)
+			assert.Equal(t, common.LayoutPlanar, format.Layout)
+			assert.Equal(t, common.TileFormatNES, tileFormat)
+			assert.Equal(t, common.CompressionRLE, compression)
+		}},
+		{"size", []string{"-metatile-width", "4", "-metatile-height", "3"}, func(t *testing.T, format common.MetatileFormat, _ common.TileFormat, _ common.Compression) {
+			assert.Equal(t, common.MetatileSize{Width: 4, Height: 3}, format.Size)
+			assert.Equal(t, common.LayoutPlanar, format.Layout)
+		}},
+		{"layout and addressing", []string{"-layout", "column_major", "-addressing", "8800"}, func(t *testing.T, format common.MetatileFormat, _ common.TileFormat, _ common.Compression) {
+			assert.Equal(t, common.LayoutColumnMajor, format.Layout)
+			assert.Equal(t, common.Addressing8800, format.Addressing)
+		}},
+		{"tile format", []string{"-tile-format", "gb"}, func(t *testing.T, _ common.MetatileFormat, tileFormat common.TileFormat, _ common.Compression) {
+			assert.Equal(t, common.TileFormatGB, tileFormat)
+		}},
+		{"compression", []string{"-compression", "lzss"}, func(t *testing.T, _ common.MetatileFormat, _ common.TileFormat, compression common.Compression) {
+			assert.Equal(t, common.CompressionLZSS, compression)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set := newFlagSet("test")
+			f := addFormatFlags(set)
+			assert.NoError(t, set.Parse(test.args))
+			format, err := f.apply(cfg.MetatileFormat)
+			assert.NoError(t, err)
+			tileFormat, err := f.applyTileFormat(cfg.TileFormat)
+			assert.NoError(t, err)
+			compression, err := f.applyCompression(cfg.Compression)
+			assert.NoError(t, err)
+			test.check(t, format, tileFormat, compression)
+		})
+	}
+
+	_, err = (&formatFlags{layout: "diagonal"}).apply(cfg.MetatileFormat)
+	assert.Error(t, err)
+	_, err = (&formatFlags{addressing: "9000"}).apply(cfg.MetatileFormat)
+	assert.Error(t, err)
+	_, err = (&formatFlags{tileFormat: "sms"}).applyTileFormat(cfg.TileFormat)
+	assert.Error(t, err)
+	_, err = (&formatFlags{compression: "zip"}).applyCompression(cfg.Compression)
+	assert.Error(t, err)
+}
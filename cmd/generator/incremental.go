@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/file_manager"
+	"github.com/Onlymiind/tileset_manager/internal/manifest"
+)
+
+// builds skips entries whose settings and input files didn't change since the last run, see manifest.Manifest
+type builds struct {
+	// nil if the output is written to stdout
+	manifest *manifest.Manifest
+	// Settings shared by all entries
+	config  string
+	force   bool
+	skipped int32
+}
+
+// newBuilds loads the manifest from the output directory. With force every entry is rebuilt,
+// but the manifest is still updated
+func newBuilds(cfg *common.Config, force bool) (*builds, error) {
+	b := &builds{
+		config: fmt.Sprintf("%v|%v|%+v|%+v|%v|%v|%v|%+v|%v", cfg.Palette, cfg.CGBPalettes, cfg.Output, cfg.MetatileFormat, cfg.TileFormat, cfg.Compression, cfg.BitColors, cfg.EmptyTile, cfg.Strict),
+		force:  force,
+	}
+	if cfg.Output.IsStdout() {
+		return b, nil
+	}
+
+	var err error
+	b.manifest, err = manifest.Load(path.Join(cfg.Output.Directory, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// build runs f with a tracked manager unless the entry is up to date, f returns input files of the entry.
+// Returns input files of the entry, also when it is skipped
+func (b *builds) build(manager *file_manager.Manager, key string, settings any, f func(manager *file_manager.Manager) ([]string, error)) ([]string, error) {
+	if b.manifest == nil {
+		return f(manager)
+	}
+
+	hash := manifest.Hash([]byte(fmt.Sprintf("%s|%+v", b.config, settings)))
+	if !b.force && b.manifest.UpToDate(key, hash) {
+		atomic.AddInt32(&b.skipped, 1)
+		return b.manifest.Inputs(key), nil
+	}
+
+	tracked := manager.Tracked()
+	inputs, err := f(tracked)
+	if err != nil {
+		b.manifest.Remove(key)
+		return inputs, err
+	}
+	for _, input := range inputs {
+		if input == common.StdStream {
+			b.manifest.Remove(key)
+			return inputs, nil
+		}
+	}
+	return inputs, b.manifest.Record(key, hash, inputs, tracked.Written())
+}
+
+// process builds tile and metatile data, see process
+func (b *builds) process(cfg *common.Config, manager *file_manager.Manager, key string, entry common.Manual, writeTileData bool) ([]string, error) {
+	return b.build(manager, key, entry, func(manager *file_manager.Manager) ([]string, error) {
+		return entryInputs(cfg, entry), process(cfg, manager, entry, writeTileData)
+	})
+}
+
+// forget drops hashes of changed files, see manifest.Manifest.Forget
+func (b *builds) forget(files []string) {
+	if b.manifest != nil {
+		b.manifest.Forget(files...)
+	}
+}
+
+// save writes the manifest and reports the number of skipped entries
+func (b *builds) save() error {
+	if b.manifest == nil {
+		return nil
+	}
+	if skipped := atomic.SwapInt32(&b.skipped, 0); skipped != 0 {
+		fmt.Fprintf(os.Stderr, "%d entries are up to date\n", skipped)
+	}
+	return b.manifest.Save()
+}
+
+// entryInputs returns files read by process, including the files referenced by the metatiles
+func entryInputs(cfg *common.Config, entry common.Manual) []string {
+	inputs := []string{entry.TileData}
+	if len(entry.MetatileData) == 0 {
+		return inputs
+	}
+
+	inputs = append(inputs, entry.MetatileData)
+	for _, file := range []string{entry.AttributeData, entry.Bank1TileData, cfg.EmptyTile.File} {
+		if len(file) != 0 {
+			inputs = append(inputs, file)
+		}
+	}
+	return inputs
+}
+
+// refInputs returns files referenced by tile refs
+func refInputs(refs common.TileRefs) []string {
+	var inputs []string
+	for node := refs.Begin(); node != nil; node = node.Next() {
+		inputs = append(inputs, node.GetValue().File)
+	}
+	return inputs
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
+)
+
+func runInfo(args []string) int {
+	set := newFlagSet("info")
+	cfgFlags := addConfigFlags(set)
+	fmtFlags := addFormatFlags(set)
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+	if set.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "expected files")
+		set.Usage()
+		return exitUsage
+	}
+
+	cfg, err := cfgFlags.load()
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	err = cfgFlags.apply(cfg)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	format, err := fmtFlags.apply(cfg.MetatileFormat)
+	if err == nil {
+		cfg.TileFormat, err = fmtFlags.applyTileFormat(cfg.TileFormat)
+	}
+	if err == nil {
+		cfg.Compression, err = fmtFlags.applyCompression(cfg.Compression)
+	}
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, file := range set.Args() {
+		data, err := loadFile(cfg, format, file)
+		if err != nil {
+			printError(err, file)
+			code = exitFailure
+			continue
+		}
+		printInfo(file, data)
+	}
+	return code
+}
+
+// loadFile loads a config, JSON data file, binary metatile data or tile data in any supported format.
+// The result is *common.Config, *common.Tiles, *common.Metatiles or *common.TileMap
+func loadFile(cfg *common.Config, format common.MetatileFormat, file string) (any, error) {
+	data, err := common.ReadFile(file)
+	if err != nil {
+		return nil, common.Wrap(err, "failed to read file")
+	}
+
+	ext := path.Ext(file)
+	if len(ext) == 0 {
+		ext = tileset.DetectExtension(data)
+	}
+	switch ext {
+	case common.ExtensionJSON:
+		return serializer.ParseData(file, data, cfg.Strict)
+	case common.ExtensionMetatileData:
+		refs := common.NewTileRefs()
+		return tileset.LoadMetatiles(common.HostFS, file, "", refs, format)
+	default:
+		return tileset.LoadTiles(common.HostFS, file, tileset.TileOptions{
+			Format:      cfg.TileFormat,
+			Compression: cfg.Compression,
+			BitColors:   cfg.BitColors,
+			Palette:     cfg.Palette,
+			Strict:      cfg.Strict,
+		})
+	}
+}
+
+func printInfo(file string, data any) {
+	switch data := data.(type) {
+	case *common.Tiles:
+		fmt.Printf("%s: tile data, %d tiles\n", file, len(data.Data))
+		if len(data.Palette) != 0 {
+			fmt.Printf("  palette: %d colors\n", len(data.Palette))
+		}
+	case *common.Metatiles:
+		fmt.Printf("%s: metatile data, %d metatiles of %dx%d tiles\n", file, len(data.Metatiles), data.Size.Width, data.Size.Height)
+		printMetatileInfo(data)
+	case *common.TileMap:
+		fmt.Printf("%s: map, %dx%d cells\n", file, data.Width, data.Height)
+		if len(data.MetatileFile) != 0 {
+			fmt.Printf("  metatiles: %s\n", data.MetatileFile)
+		}
+		if len(data.CellFile) != 0 {
+			fmt.Printf("  cells: %s\n", data.CellFile)
+		}
+		if len(data.AttributeFile) != 0 {
+			fmt.Printf("  attributes: %s\n", data.AttributeFile)
+		}
+		printRefs(data.Refs)
+	case *common.Config:
+		fmt.Printf("%s: config, %d manual entries, %d images to compile, %d files to convert\n", file, len(data.Manual), len(data.Compile), len(data.ConvertToPng))
+		if len(data.Auto) != 0 {
+			fmt.Printf("  auto: %s\n", data.Auto)
+		}
+		fmt.Printf("  palette: %d colors, %d CGB palettes\n", len(data.Palette), len(data.CGBPalettes))
+	}
+}
+
+func printMetatileInfo(mtiles *common.Metatiles) {
+	indexes := map[uint8]bool{}
+	hasAttributes := false
+	for i := range mtiles.Metatiles {
+		for _, index := range mtiles.Metatiles[i].Tiles {
+			indexes[index] = true
+		}
+		hasAttributes = hasAttributes || len(mtiles.Metatiles[i].Attributes) != 0
+	}
+	fmt.Printf("  %d unique tile indexes\n", len(indexes))
+	if hasAttributes {
+		fmt.Println("  CGB attributes")
+	}
+	if mtiles.Addressing == common.Addressing8800 {
+		fmt.Println("  $8800 addressing")
+	}
+	if len(mtiles.CGBPalettes) != 0 {
+		fmt.Printf("  %d CGB palettes\n", len(mtiles.CGBPalettes))
+	}
+	if mtiles.AbsentTiles.Size() != 0 {
+		fmt.Printf("  %d ranges of absent tiles\n", mtiles.AbsentTiles.Size())
+	}
+	printRefs(mtiles.Refs)
+}
+
+func printRefs(refs common.TileRefs) {
+	for node := refs.Begin(); node != nil; node = node.Next() {
+		ref := node.GetValue()
+		fmt.Printf("  tiles %02x:%02x@%d: %s:%02x\n", ref.Range.Start, ref.Range.End, ref.Bank, ref.File, ref.Offset)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// job processes a single file, messages and errors are written to log. Returns false if the job failed
+type job func(log io.Writer) bool
+
+// runJobs runs jobs on up to workers goroutines, 0 means the number of CPUs.
+// Logs are written to stderr in the order of jobs, regardless of the order in which jobs finish.
+// Returns false if any of the jobs failed
+func runJobs(workers int, jobs []job) bool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	logs := make([]bytes.Buffer, len(jobs))
+	results := make([]bool, len(jobs))
+	done := make([]chan struct{}, len(jobs))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = runJob(jobs[i], &logs[i])
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range jobs {
+			indexes <- i
+		}
+		close(indexes)
+	}()
+
+	ok := true
+	for i := range jobs {
+		<-done[i]
+		os.Stderr.Write(logs[i].Bytes())
+		logs[i] = bytes.Buffer{}
+		ok = results[i] && ok
+	}
+	wg.Wait()
+	return ok
+}
+
+// runJob reports a panic as a failure of the job, since it can't be recovered in main
+func runJob(j job, log io.Writer) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintln(log, "panic:", err)
+			ok = false
+		}
+	}()
+	return j(log)
+}
+
+// logResult writes err to log, returns true if there is no error
+func logResult(log io.Writer, err error) bool {
+	if err != nil {
+		logError(log, err)
+		return false
+	}
+	return true
+}
@@ -1,24 +1,46 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io/fs"
+	"io"
 	"log"
 	"os"
-	"path"
-	"path/filepath"
 	"strings"
 
 	"github.com/Onlymiind/tileset_manager/internal/common"
-	"github.com/Onlymiind/tileset_manager/internal/file_manager"
-	"github.com/Onlymiind/tileset_manager/internal/serializer"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		log.Fatalln("expected path to a config file as an argument")
+const (
+	exitOK = iota
+	// Some of the files could not be processed or differ
+	exitFailure
+	exitUsage
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"extract", "[flags] [tile data files...]", "convert tile and metatile data to PNG and JSON", runExtract},
+		{"compile", "[flags] [images...]", "convert PNG tilesheets to tile and metatile data", runCompile},
+		{"watch", "[flags] -config config", "run extract and re-run it for changed files until interrupted", runWatch},
+		{"convert", "[flags] [files...]", "render .tile.json, .mtile.json and .map.json files to PNG", runConvert},
+		{"tmx", "[flags] maps.tmx...", "import tile layers of Tiled maps as .map.json files", runTMX},
+		{"info", "[flags] files...", "print a summary of tile, metatile, map and config files", runInfo},
+		{"validate", "files...", "check configs and data files against the JSON schemas", runValidate},
+		{"diff", "[flags] file file", "compare tile or metatile data, exits with 1 if the data differs", runDiff},
 	}
+}
 
+func main() {
 	defer func() {
 		err := recover()
 		if err != nil {
@@ -28,211 +50,79 @@ func main() {
 		}
 	}()
 
-	cfg, err := serializer.ParseConfig(os.Args[1])
-	if err != nil {
-		log.Fatalln(err.Error())
-	}
-
-	outDirs := []string{
-		cfg.Output.GetOutputPath(false, false),
-		cfg.Output.GetOutputPath(true, false),
-		cfg.Output.GetOutputPath(false, true),
-		cfg.Output.GetOutputPath(true, true),
-	}
-
-	for i := range outDirs {
-		if len(outDirs[i]) == 0 {
-			continue
-		}
-		err = os.MkdirAll(outDirs[i], 0777)
-		if err != nil {
-			log.Fatalln("could not create output directory", outDirs[i])
-		}
-	}
-
-	manager := file_manager.NewManager(cfg)
-	fileWalkerWrapper := func(filePath string, info fs.FileInfo, err error) error {
-		return fileWalker(cfg, manager, filePath, info, err)
-	}
-
-	if len(cfg.Auto) != 0 {
-		err = filepath.Walk(cfg.Auto, fileWalkerWrapper)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
-	}
-
-	fmt.Printf("%f %s\n", manager.CacheSize().As(common.Kilobytes), "kb")
-
-	processManual(cfg, manager)
-
-	fmt.Printf("%f %s\n", manager.CacheSize().As(common.Kilobytes), "kb")
-
-	processConvertToPNG(cfg, manager)
-
-	fmt.Printf("%f %s\n", manager.CacheSize().As(common.Kilobytes), "kb")
-
-	// f, _ := os.OpenFile("out/png/queen.png", os.O_RDONLY, 0666)
-	// img, _ := png.Decode(f)
-
-	// _ = img
-
-	// fmt.Println()
+	os.Exit(run(os.Args[1:]))
 }
 
-func process(cfg *common.Config, manager *file_manager.Manager, tilePath, metatilePath, name string, writeTileData bool) error {
-	tileData, err := file_manager.ExtractTileData(tilePath)
-	if err != nil {
-		return common.Wrap(err, "failed to extract tile data", tilePath)
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
 	}
-	tileData.Palette = cfg.Palette
 
-	if writeTileData {
-		json := serializer.SerializeTileData(tileData)
-		err = manager.WriteJSON(json, name+".tile", true)
-		if err != nil {
-			return common.Wrap(err, "failed to write json", tilePath)
-		}
-
-		png := file_manager.TileDataToImage(tileData)
-		err = manager.WritePNG(png, name, true)
-		if err != nil {
-			return common.Wrap(err, "failed to write png", tilePath)
-		}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK
 	}
-	if len(metatilePath) != 0 {
-		refs := common.NewTree(func(lhs, rhs *common.TileRef) bool { return lhs.Less(rhs) })
-		refs.Insert(common.TileRef{
-			File: tilePath,
-			Range: common.IndexRange{
-				Start: 0,
-				End:   uint8(len(tileData.Data)),
-			},
-		})
-		if len(cfg.EmptyTile.File) != 0 {
-			refs.Insert(cfg.EmptyTile)
-		}
-
-		mtiles, err := file_manager.ExtractMetatileData(metatilePath, refs)
-		if err != nil {
-			return common.Wrap(err, "failed to extract metatile data")
-		}
-		if len(mtiles.Palette) == 0 {
-			mtiles.Palette = cfg.Palette
-		}
-
-		json := serializer.SerializeMetatileData(cfg.Palette, mtiles)
-		err = manager.WriteJSON(json, name+".mtile", false)
-		if err != nil {
-			return common.Wrap(err, "failed to write json", tilePath)
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
 		}
+	}
 
-		png := manager.MetatileToImage(mtiles)
-		err = manager.WritePNG(png, name, false)
-		if err != nil {
-			return common.Wrap(err, "failed to write png", tilePath)
-		}
+	// Path to a config is accepted for compatibility
+	if strings.HasSuffix(args[0], common.ExtensionJSON) {
+		return runExtract(append([]string{"-config"}, args...))
 	}
 
-	return nil
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
 }
 
-func processManual(cfg *common.Config, manager *file_manager.Manager) {
-	for i := range cfg.Manual {
-		info, err := os.Stat(cfg.Manual[i].TileData)
-		if err != nil {
-			fmt.Printf("could not get tile data file info, path: %s, error: %s\n", cfg.Manual[i].TileData, err.Error())
-			continue
-		}
-		name := strings.TrimSuffix(info.Name(), path.Ext(info.Name()))
-
-		metatilePath := ""
-		if cfg.Manual[i].MetatileData != "" {
-			info, err := os.Stat(cfg.Manual[i].MetatileData)
-			if err != nil {
-				fmt.Printf("could not get metatile data file info, path: %s, error %s\n", cfg.Manual[i].MetatileData, err.Error())
-			} else if file_manager.IsMetatileData(info) {
-				metatilePath = cfg.Manual[i].MetatileData
-				name = strings.TrimSuffix(info.Name(), common.ExtensionMetatileData)
-			}
-		}
-
-		mInfo, err := os.Stat(metatilePath)
-		if err != nil || !file_manager.IsMetatileData(mInfo) {
-			metatilePath = ""
-		}
-
-		if len(cfg.Manual[i].Name) != 0 {
-			name = cfg.Manual[i].Name
-		}
-
-		err = process(cfg, manager, cfg.Manual[i].TileData, metatilePath, name, false)
-		if err != nil {
-			fmt.Println(err.Error())
-		}
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: tileset_manager <command> [flags] [files...]")
+	fmt.Fprintln(w, "       tileset_manager <config.json>")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintln(w, "\nUse - instead of a file to read from stdin, -out - writes the output to stdout.")
+	fmt.Fprintln(w, "Run tileset_manager <command> -help for the list of flags.")
 }
 
-func processConvertToPNG(cfg *common.Config, manager *file_manager.Manager) {
-	for i := range cfg.ConvertToPng {
-		info, err := os.Stat(cfg.ConvertToPng[i])
-		if err != nil {
-			fmt.Printf("failed to get file info %s, error: %s\n", cfg.ConvertToPng[i], err.Error())
-		}
-		name := info.Name()
-		for ext := path.Ext(name); len(ext) != 0; ext = path.Ext(name) {
-			name = strings.TrimSuffix(name, ext)
-		}
-		if strings.HasSuffix(cfg.ConvertToPng[i], ".tile.json") {
-			tileData, err := serializer.ParseTileData(cfg.ConvertToPng[i])
-			if err != nil {
-				fmt.Println(err.Error(), cfg.ConvertToPng[i])
-			}
-
-			if len(tileData.Palette) == 0 {
-				tileData.Palette = cfg.Palette
-			}
-
-			img := file_manager.TileDataToImage(tileData)
-			err = manager.WritePNG(img, name, true)
-			if err != nil {
-				fmt.Println(err.Error(), cfg.ConvertToPng[i])
-			}
-
-		} else if strings.HasSuffix(cfg.ConvertToPng[i], ".mtile.json") {
-			tileset, err := serializer.ParseMetatileData(cfg.ConvertToPng[i])
-			if err != nil {
-				fmt.Println(err.Error(), cfg.ConvertToPng[i])
-			}
-
-			if len(tileset.Palette) == 0 {
-				tileset.Palette = cfg.Palette
-			}
-
-			img := manager.MetatileToImage(tileset)
-			err = manager.WritePNG(img, name, false)
-			if err != nil {
-				fmt.Println(err.Error(), cfg.ConvertToPng[i])
+// newFlagSet creates a flag set for the command with usage built from the command description
+func newFlagSet(name string) *flag.FlagSet {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(set.Output(), "usage: tileset_manager %s %s\n\n%s\n\nflags:\n", cmd.name, cmd.args, cmd.summary)
 			}
 		}
+		set.PrintDefaults()
 	}
+	return set
 }
 
-func fileWalker(cfg *common.Config, manager *file_manager.Manager, filePath string, info fs.FileInfo, err error) error {
-	if err != nil {
-		return err
+// parseFlags returns exit code and false if the command should not run
+func parseFlags(set *flag.FlagSet, args []string) (int, bool) {
+	err := set.Parse(args)
+	switch {
+	case err == flag.ErrHelp:
+		return exitOK, false
+	case err != nil:
+		return exitUsage, false
+	default:
+		return exitOK, true
 	}
+}
 
-	if file_manager.IsTileData(info) {
-
-		name := strings.TrimSuffix(info.Name(), path.Ext(info.Name()))
-		metatilePath := common.ReplaceLast(filePath, common.ExtensionTileData, common.ExtensionMetatileData)
-		mInfo, err := os.Stat(metatilePath)
-		if err != nil || !file_manager.IsMetatileData(mInfo) {
-			metatilePath = ""
-		}
-		return process(cfg, manager, filePath, metatilePath, name, true)
-	}
+// printError writes errors to stderr, so that they don't mix with the output written to stdout
+func printError(err error, msgs ...any) {
+	logError(os.Stderr, err, msgs...)
+}
 
-	return nil
+func logError(log io.Writer, err error, msgs ...any) {
+	fmt.Fprintln(log, append([]any{err.Error()}, msgs...)...)
 }
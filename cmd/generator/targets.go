@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/file_manager"
+)
+
+// target is a single entry processed by extract, convert and watch
+type target struct {
+	key string
+	// Files which decide how the entry is processed, even if they don't exist, e.g. .mtile next to .chr in "auto"
+	watch []string
+	// Files read by the last run
+	inputs []string
+	run    func(log io.Writer) ([]string, bool)
+}
+
+// runTargets runs targets on the worker pool and remembers their inputs, returns false if any of them failed
+func runTargets(workers int, targets []*target) bool {
+	jobs := make([]job, 0, len(targets))
+	for _, t := range targets {
+		t := t
+		jobs = append(jobs, func(log io.Writer) bool {
+			inputs, ok := t.run(log)
+			t.inputs = inputs
+			return ok
+		})
+	}
+	return runJobs(workers, jobs)
+}
+
+// fileTarget processes a tile data file passed to extract, entry holds the rest of the flags
+func fileTarget(cfg *common.Config, manager *file_manager.Manager, b *builds, entry common.Manual) *target {
+	key := "extract:" + entry.TileData + ":" + entry.Name
+	return &target{
+		key:   key,
+		watch: entryInputs(cfg, entry),
+		run: func(log io.Writer) ([]string, bool) {
+			inputs, err := b.process(cfg, manager.WithLog(log), key, entry, len(entry.MetatileData) == 0)
+			return inputs, logResult(log, err)
+		},
+	}
+}
+
+// autoTargets returns tile data files from the "auto" directory in lexical order
+func autoTargets(cfg *common.Config, manager *file_manager.Manager, b *builds) ([]*target, error) {
+	if len(cfg.Auto) == 0 {
+		return nil, nil
+	}
+
+	var targets []*target
+	err := filepath.Walk(cfg.Auto, func(filePath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file_manager.IsTileData(info) {
+			targets = append(targets, autoTarget(cfg, manager, b, filePath))
+		}
+		return nil
+	})
+	return targets, err
+}
+
+func autoTarget(cfg *common.Config, manager *file_manager.Manager, b *builds, filePath string) *target {
+	key := "auto:" + filePath
+	trimmed := common.TrimCompression(filePath)
+	return &target{
+		key: key,
+		watch: []string{
+			filePath,
+			common.ReplaceLast(trimmed, common.ExtensionTileData, common.ExtensionMetatileData),
+			common.ReplaceLast(trimmed, common.ExtensionTileData, common.ExtensionAttributes),
+		},
+		run: func(log io.Writer) ([]string, bool) {
+			inputs, err := b.process(cfg, manager.WithLog(log), key, autoEntry(cfg, filePath), true)
+			return inputs, logResult(log, err)
+		},
+	}
+}
+
+// autoEntry finds metatile and attribute data with the same name as the tile data, ignoring the compression extension
+func autoEntry(cfg *common.Config, filePath string) common.Manual {
+	trimmed := common.TrimCompression(filePath)
+	name := filepath.Base(trimmed)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	metatilePath := common.ReplaceLast(trimmed, common.ExtensionTileData, common.ExtensionMetatileData)
+	mInfo, err := os.Stat(metatilePath)
+	if err != nil || !file_manager.IsMetatileData(mInfo) {
+		metatilePath = ""
+	}
+	attributePath := common.ReplaceLast(trimmed, common.ExtensionTileData, common.ExtensionAttributes)
+	aInfo, err := os.Stat(attributePath)
+	if err != nil || !aInfo.Mode().IsRegular() {
+		attributePath = ""
+	}
+	return common.Manual{
+		TileData:      filePath,
+		MetatileData:  metatilePath,
+		AttributeData: attributePath,
+		Name:          name,
+		Format:        cfg.MetatileFormat,
+		TileFormat:    cfg.TileFormat,
+		Compression:   cfg.Compression,
+	}
+}
+
+func manualTargets(cfg *common.Config, manager *file_manager.Manager, b *builds) []*target {
+	targets := make([]*target, 0, len(cfg.Manual))
+	for i := range cfg.Manual {
+		entry := cfg.Manual[i]
+		key := "manual:" + entry.TileData + ":" + entry.Name
+		watch := []string{entry.TileData}
+		if len(entry.MetatileData) != 0 {
+			watch = append(watch, entry.MetatileData)
+		}
+		targets = append(targets, &target{
+			key:   key,
+			watch: watch,
+			run: func(log io.Writer) ([]string, bool) {
+				return processManual(cfg, manager, b, key, entry, log)
+			},
+		})
+	}
+	return targets
+}
+
+// processManual resolves the output name and checks that metatile data exists before processing the entry
+func processManual(cfg *common.Config, manager *file_manager.Manager, b *builds, key string, entry common.Manual, log io.Writer) ([]string, bool) {
+	info, err := os.Stat(entry.TileData)
+	if err != nil {
+		fmt.Fprintf(log, "could not get tile data file info, path: %s, error: %s\n", entry.TileData, err.Error())
+		return nil, false
+	}
+	name := common.TrimCompression(info.Name())
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	ok := true
+	metatilePath := ""
+	if entry.MetatileData != "" {
+		info, err := os.Stat(entry.MetatileData)
+		if err != nil {
+			fmt.Fprintf(log, "could not get metatile data file info, path: %s, error %s\n", entry.MetatileData, err.Error())
+			ok = false
+		} else if file_manager.IsMetatileData(info) {
+			metatilePath = entry.MetatileData
+			name = strings.TrimSuffix(info.Name(), common.ExtensionMetatileData)
+		}
+	}
+
+	if len(entry.Name) != 0 {
+		name = entry.Name
+	}
+
+	entry.MetatileData = metatilePath
+	entry.Name = name
+	inputs, err := b.process(cfg, manager.WithLog(log), key, entry, false)
+	return inputs, logResult(log, err) && ok
+}
+
+func convertTargets(cfg *common.Config, manager *file_manager.Manager, b *builds, files []string) []*target {
+	targets := make([]*target, 0, len(files))
+	for _, file := range files {
+		file := file
+		key := "convert:" + file
+		targets = append(targets, &target{
+			key:   key,
+			watch: []string{file},
+			run: func(log io.Writer) ([]string, bool) {
+				inputs, err := b.build(manager, key, file, func(manager *file_manager.Manager) ([]string, error) {
+					return convertToPNG(cfg, manager, file)
+				})
+				if err != nil {
+					logError(log, err)
+					return inputs, false
+				}
+				return inputs, true
+			},
+		})
+	}
+	return targets
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/file_manager"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
+)
+
+func runTMX(args []string) int {
+	set := newFlagSet("tmx")
+	cfgFlags := addConfigFlags(set)
+	layer := set.String("layer", "", "name of the tile layer to import, defaults to the first one")
+	name := set.String("name", "", "output file name, only used with a single map")
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+	if set.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "expected maps to import")
+		set.Usage()
+		return exitUsage
+	}
+	if set.NArg() != 1 && len(*name) != 0 {
+		fmt.Fprintln(os.Stderr, "-name requires a single map")
+		return exitUsage
+	}
+
+	cfg, err := cfgFlags.load()
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	err = cfgFlags.apply(cfg)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	err = createOutputDirs(cfg)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	manager := file_manager.NewManager(cfg)
+	failed := false
+	for _, file := range set.Args() {
+		outName := *name
+		if len(outName) == 0 {
+			outName = outputName(file)
+		}
+		if err := importTMX(cfg, manager, file, *layer, outName); err != nil {
+			printError(err, file)
+			failed = true
+		}
+	}
+	if failed {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// importTMX writes the map as <name>.map.json. Metatiles are written to the output directory under the name
+// of their .mtile.json file with the tile properties of the tileset, so that properties edited in Tiled are kept
+func importTMX(cfg *common.Config, manager *file_manager.Manager, file, layer, name string) error {
+	tileMap, err := tileset.ReadTMX(common.HostFS, file, layer, cfg.Strict)
+	if err != nil {
+		return err
+	}
+	if tileMap.Metatiles != nil {
+		mtileName := outputName(tileMap.MetatileFile)
+		err = manager.WriteMetatileJSON(tileMap.Metatiles, mtileName)
+		if err != nil {
+			return err
+		}
+		tileMap.MetatileFile = manager.MetatileJSONPath(mtileName)
+	}
+	return manager.WriteMapJSON(tileMap, name)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+)
+
+func runValidate(args []string) int {
+	set := newFlagSet("validate")
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+	if set.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "expected files to validate")
+		set.Usage()
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, file := range set.Args() {
+		err := serializer.ValidateFile(file)
+		if err != nil {
+			printError(err)
+			code = exitFailure
+		}
+	}
+	return code
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/file_manager"
+)
+
+func runWatch(args []string) int {
+	set := newFlagSet("watch")
+	cfgFlags := addConfigFlags(set)
+	fmtFlags := addFormatFlags(set)
+	interval := set.Duration("interval", 500*time.Millisecond, "how often files are checked for changes")
+	force := set.Bool("force", false, "rebuild all entries on start even if their inputs didn't change")
+	if code, ok := parseFlags(set, args); !ok {
+		return code
+	}
+	if len(cfgFlags.config) == 0 || set.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "expected a config")
+		set.Usage()
+		return exitUsage
+	}
+
+	cfg, err := cfgFlags.load()
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	err = cfgFlags.apply(cfg)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	cfg.MetatileFormat, err = fmtFlags.apply(cfg.MetatileFormat)
+	if err == nil {
+		cfg.TileFormat, err = fmtFlags.applyTileFormat(cfg.TileFormat)
+	}
+	if err == nil {
+		cfg.Compression, err = fmtFlags.applyCompression(cfg.Compression)
+	}
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	err = createOutputDirs(cfg)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	b, err := newBuilds(cfg, *force)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	w, err := newWatcher(cfg, b)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	w.build(w.auto, w.manual, w.convert)
+	b.force = false
+	w.changedFiles()
+	fmt.Fprintln(os.Stderr, "watching for changes, press Ctrl+C to stop")
+
+	for {
+		time.Sleep(*interval)
+		w.poll()
+	}
+}
+
+// watcher re-runs targets whose files changed. Changes to the config itself are not tracked
+type watcher struct {
+	cfg     *common.Config
+	manager *file_manager.Manager
+	builds  *builds
+	auto    []*target
+	manual  []*target
+	convert []*target
+	stamps  map[string]common.FileStamp
+}
+
+func newWatcher(cfg *common.Config, b *builds) (*watcher, error) {
+	manager := file_manager.NewManager(cfg)
+	w := &watcher{
+		cfg:     cfg,
+		manager: manager,
+		builds:  b,
+		manual:  manualTargets(cfg, manager, b),
+		convert: convertTargets(cfg, manager, b, cfg.ConvertToPng),
+		stamps:  map[string]common.FileStamp{},
+	}
+	var err error
+	w.auto, err = autoTargets(cfg, manager, b)
+	return w, err
+}
+
+// poll rescans the "auto" directory and re-runs new targets and targets with changed files
+func (w *watcher) poll() {
+	auto, err := autoTargets(w.cfg, w.manager, w.builds)
+	if err != nil {
+		printError(err)
+		return
+	}
+	// Known targets are kept, since their inputs are only known after a run
+	known := make(map[string]*target, len(w.auto))
+	for _, t := range w.auto {
+		known[t.key] = t
+	}
+	added := map[*target]bool{}
+	for i, t := range auto {
+		if old, ok := known[t.key]; ok {
+			auto[i] = old
+		} else {
+			added[t] = true
+		}
+	}
+	w.auto = auto
+
+	changed := w.changedFiles()
+	if len(changed) == 0 && len(added) == 0 {
+		return
+	}
+	files := make([]string, 0, len(changed))
+	for file := range changed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	w.manager.Invalidate(files...)
+	w.builds.forget(files)
+
+	phases := [][]*target{w.auto, w.manual, w.convert}
+	for i, phase := range phases {
+		var affected []*target
+		for _, t := range phase {
+			if added[t] || t.affectedBy(changed) {
+				affected = append(affected, t)
+			}
+		}
+		phases[i] = affected
+	}
+	count := w.build(phases...)
+	// Outputs of the targets might be inputs of other targets, so they are only checked by the next poll
+	w.stampNewFiles()
+	fmt.Fprintf(os.Stderr, "%s: %d files changed, %d entries processed\n", time.Now().Format("15:04:05"), len(files), count)
+}
+
+// build runs targets phase by phase and saves the manifest, returns the number of targets
+func (w *watcher) build(phases ...[]*target) int {
+	count := 0
+	for _, phase := range phases {
+		runTargets(w.cfg.Jobs, phase)
+		count += len(phase)
+	}
+	if err := w.builds.save(); err != nil {
+		printError(err)
+	}
+	return count
+}
+
+// changedFiles updates stamps of the files watched by targets and returns the files that changed.
+// Files seen for the first time are not reported
+func (w *watcher) changedFiles() map[string]bool {
+	changed := map[string]bool{}
+	for file := range w.files() {
+		current := common.NewFileStamp(file)
+		previous, ok := w.stamps[file]
+		w.stamps[file] = current
+		if ok && previous != current {
+			changed[file] = true
+		}
+	}
+	return changed
+}
+
+func (w *watcher) stampNewFiles() {
+	for file := range w.files() {
+		if _, ok := w.stamps[file]; !ok {
+			w.stamps[file] = common.NewFileStamp(file)
+		}
+	}
+}
+
+// files returns the files watched and read by targets
+func (w *watcher) files() map[string]bool {
+	files := map[string]bool{}
+	for _, phase := range [][]*target{w.auto, w.manual, w.convert} {
+		for _, t := range phase {
+			for _, file := range t.watch {
+				files[file] = true
+			}
+			for _, file := range t.inputs {
+				files[file] = true
+			}
+		}
+	}
+	delete(files, common.StdStream)
+	return files
+}
+
+func (t *target) affectedBy(changed map[string]bool) bool {
+	for _, files := range [][]string{t.watch, t.inputs} {
+		for _, file := range files {
+			if changed[file] {
+				return true
+			}
+		}
+	}
+	return false
+}
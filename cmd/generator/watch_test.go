@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+	write := func(name string, data []byte) {
+		assert.NoError(t, os.WriteFile(file(name), data, 0666))
+	}
+	tile := make([]byte, 16)
+
+	assert.NoError(t, os.Mkdir(file("auto"), 0777))
+	write("auto/a.chr", tile)
+	write("shared.chr", tile)
+	write("other.chr", tile)
+	// Both metatile files are only connected to their tile data through tile references
+	for name, tiles := range map[string]string{"a.mtile.json": "shared.chr", "b.mtile.json": "other.chr"} {
+		write(name, []byte(fmt.Sprintf(`{"type": "mtiles", "tiles": {"0": %q}, "metatiles": [{"tl": "0", "tr": "0", "bl": "0", "br": "0"}]}`, file(tiles))))
+	}
+	write("config.json", []byte(fmt.Sprintf(`{"palette": ["ffffff", "aaaaaa", "555555", "000000"], "auto": %q, "convert_to_png": [%q, %q], "output": {"directory": %q}, "jobs": 1}`,
+		file("auto"), file("a.mtile.json"), file("b.mtile.json"), file("out"))))
+
+	cfg, err := (&configFlags{config: file("config.json")}).load()
+	assert.NoError(t, err)
+	assert.NoError(t, createOutputDirs(cfg))
+	b, err := newBuilds(cfg, false)
+	assert.NoError(t, err)
+	w, err := newWatcher(cfg, b)
+	assert.NoError(t, err)
+
+	runs := map[string]int{}
+	for _, target := range w.convert {
+		run, key := target.run, target.key
+		target.run = func(log io.Writer) ([]string, bool) {
+			runs[key]++
+			return run(log)
+		}
+	}
+	w.build(w.auto, w.manual, w.convert)
+	assert.Empty(t, w.changedFiles())
+	assert.Equal(t, map[string]int{"convert:" + file("a.mtile.json"): 1, "convert:" + file("b.mtile.json"): 1}, runs)
+
+	// Only the target referencing the changed tile data runs again
+	write("shared.chr", append(tile, tile...))
+	w.poll()
+	assert.Equal(t, map[string]int{"convert:" + file("a.mtile.json"): 2, "convert:" + file("b.mtile.json"): 1}, runs)
+	w.poll()
+	assert.Equal(t, map[string]int{"convert:" + file("a.mtile.json"): 2, "convert:" + file("b.mtile.json"): 1}, runs)
+
+	// New files in the "auto" directory are processed by the next poll, the known ones are kept
+	write("auto/c.chr", tile)
+	w.poll()
+	assert.Len(t, w.auto, 2)
+	assert.Equal(t, []string{file("auto/a.chr")}, w.auto[0].inputs)
+	assert.Equal(t, []string{file("auto/c.chr")}, w.auto[1].inputs)
+
+	assert.True(t, w.auto[0].affectedBy(map[string]bool{file("auto/a.chr"): true}))
+	assert.False(t, w.auto[0].affectedBy(map[string]bool{file("shared.chr"): true}))
+}
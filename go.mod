@@ -1,6 +1,6 @@
 module github.com/Onlymiind/tileset_manager
 
-go 1.19
+go 1.23
 
 require (
 	github.com/stretchr/testify v1.8.0
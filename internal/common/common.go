@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"image/color"
 	"math"
 	"path"
@@ -14,13 +15,26 @@ const (
 	DeafultCacheSizeKB    = 30
 	ExtensionTileData     = ".chr"
 	ExtensionMetatileData = ".mtile"
+	ExtensionAttributes   = ".attr"
+	ExtensionMapJSON      = ".map.json"
+	ExtensionTileJSON     = ".tile.json"
+	ExtensionMetatileJSON = ".mtile.json"
 	ExtensionJSON         = ".json"
 	ExtensionPNG          = ".png"
+	ExtensionASM          = ".asm"
+	ExtensionCHeader      = ".h"
+	ExtensionCSource      = ".c"
+	ExtensionTSX          = ".tsx"
+	ExtensionTMX          = ".tmx"
 	OutTilesPerRow        = 16
 	TileSizePx            = 8
 	BitsPerTile           = TileSizePx * TileSizePx
 	BytesPerTile          = TileSizePx * 2
-	MetatileSizePx        = TileSizePx * 2
+	DefaultMetatileSize   = 2
+	MaxCGBPalettes        = 8
+	CGBPaletteSize        = 4
+	MaxTilesPerFile       = 256
+	MaxPaletteColors      = 256
 
 	ColorBlack     uint16 = 0
 	ColorWhite     uint16 = 0xffff
@@ -31,15 +45,30 @@ const (
 type OutputType uint8
 
 const (
-	IgnorePNG OutputType = 1 << iota
-	IgnoreJSON
+	OutputPNG OutputType = 1 << iota
+	OutputJSON
+	// RGBDS assembly
+	OutputASM
+	// GBDK-style C header and source
+	OutputC
+	// Raw tile and metatile data
+	OutputRaw
+	// Tiled TSX tilesets, also writes the PNG and JSON files they reference
+	OutputTiled
+
+	DefaultOutputType = OutputPNG | OutputJSON
 )
 
+func (t OutputType) Has(flags OutputType) bool {
+	return t&flags != 0
+}
+
 type Output struct {
 	Directory     string
 	ImgDirectory  string
 	JSONDirectory string
 	TileDirectory string
+	BinDirectory  string
 	Type          OutputType
 }
 
@@ -60,41 +89,356 @@ func (o *Output) GetOutputPath(isTile bool, isJSON bool) string {
 	return path.Join(out...)
 }
 
+// IsStdout reports whether all output is written to stdout
+func (o *Output) IsStdout() bool {
+	return o.Directory == StdStream
+}
+
+func (o *Output) GetBinaryPath(isTile bool) string {
+	out := []string{
+		o.Directory,
+	}
+	if isTile && len(o.TileDirectory) != 0 {
+		out = append(out, o.TileDirectory)
+	}
+	if len(o.BinDirectory) != 0 {
+		out = append(out, o.BinDirectory)
+	}
+
+	return path.Join(out...)
+}
+
 type Config struct {
-	Auto         string
-	Output       Output
-	Manual       []Manual
-	ConvertToPng []string
-	EmptyTile    TileRef
-	Palette      []color.Color
-	CacheSize    MemorySize
+	Auto           string
+	Output         Output
+	Manual         []Manual
+	ConvertToPng   []string
+	Compile        []Compile
+	EmptyTile      TileRef
+	Palette        []color.Color
+	CGBPalettes    [][]color.Color
+	CacheSize      MemorySize
+	MetatileFormat MetatileFormat
+	// Default tile data format of entries
+	TileFormat TileFormat
+	BitColors  BitColors
+	// Default compression of tile data files of entries
+	Compression Compression
+	// Treat invalid values in config and data files as errors instead of skipping them
+	Strict bool
+	// Number of files processed in parallel, 0 means the number of CPUs
+	Jobs int
 }
 
 type Manual struct {
-	TileData     string
-	MetatileData string
-	Name         string
+	TileData      string
+	MetatileData  string
+	AttributeData string
+	// Tile data for VRAM bank 1, only used with CGB attributes
+	Bank1TileData string
+	Name          string
+	Format        MetatileFormat
+	TileFormat    TileFormat
+	Compression   Compression
+	// Write only unique tiles and rewrite indexes of the metatiles, only used with metatile data
+	Dedup bool
+	// Treat flipped copies of tiles as duplicates, only used with Dedup
+	DetectFlips bool
+}
+
+// Metatile dimensions in tiles
+type MetatileSize struct {
+	Width, Height int
+}
+
+func (s MetatileSize) TileCount() int {
+	return s.Width * s.Height
+}
+
+func (s MetatileSize) IsDefault() bool {
+	return s.Width == DefaultMetatileSize && s.Height == DefaultMetatileSize
+}
+
+// Order of tile indexes in binary metatile data
+type MetatileLayout uint8
+
+const (
+	// Each metatile is stored row by row: tl, tr, bl, br
+	LayoutRowMajor MetatileLayout = iota
+	// Each metatile is stored column by column: tl, bl, tr, br
+	LayoutColumnMajor
+	// Separate array for each tile position: all tl, then all tr, ...
+	LayoutPlanar
+)
+
+type MetatileFormat struct {
+	Size       MetatileSize
+	Layout     MetatileLayout
+	Addressing AddressingMode
+}
+
+// Tile addressing mode selected by LCDC.4
+type AddressingMode uint8
+
+const (
+	// Unsigned indexes relative to $8000
+	Addressing8000 AddressingMode = iota
+	// Signed indexes relative to $9000
+	Addressing8800
+)
+
+// Convert tile index from BG map or metatile data to the index used to look up tile references.
+// In $8800 mode tile references are relative to $8800, so index 0x80 (-128) becomes 0 and index 0 becomes 0x80
+func (a AddressingMode) RefIndex(index uint8) uint8 {
+	if a == Addressing8800 {
+		return index ^ 0x80
+	}
+	return index
+}
+
+// Binary tile data format
+type TileFormat uint8
+
+const (
+	// Game Boy: 2bpp, the low and high bit planes are interleaved row by row
+	TileFormatGB TileFormat = iota
+	// NES: 2bpp, 8 bytes of the low bit plane followed by 8 bytes of the high one
+	TileFormatNES
+	// SNES: 4bpp, planes 0 and 1 are interleaved like Game Boy tiles, followed by planes 2 and 3
+	TileFormatSNES4
+	// PC Engine background tiles, same as TileFormatSNES4
+	TileFormatPCE
+	// SNES: 8bpp, four pairs of interleaved planes
+	TileFormatSNES8
+	// GBA: 4bpp, two pixels per byte, the left one in the low nibble
+	TileFormatGBA4
+	// GBA: 8bpp, one byte per pixel
+	TileFormatGBA8
+	// 1bpp, one byte per row. Usually used for fonts, see BitColors
+	TileFormat1bpp
+)
+
+var tileFormatNames = map[TileFormat]string{
+	TileFormatGB:    "gb",
+	TileFormatNES:   "nes",
+	TileFormatSNES4: "snes4",
+	TileFormatPCE:   "pce",
+	TileFormatSNES8: "snes8",
+	TileFormatGBA4:  "gba4",
+	TileFormatGBA8:  "gba8",
+	TileFormat1bpp:  "1bpp",
+}
+
+func (f TileFormat) String() string {
+	return tileFormatNames[f]
+}
+
+// Extension of tile data files of the format: .chr for Game Boy tiles, .nes.chr for NES ones
+func (f TileFormat) Extension() string {
+	if f == TileFormatGB {
+		return ExtensionTileData
+	}
+	return "." + f.String() + ExtensionTileData
+}
+
+// Color indexes of the 0 and 1 bits of 1bpp tiles
+type BitColors [2]uint8
+
+// DefaultBitColors draw set bits with the darkest color of Game Boy palettes
+var DefaultBitColors = BitColors{0, 3}
+
+// TileFormatOf returns the tile format named by the second to last extension of the file, e.g. tiles.nes.chr.
+// Compression extensions are skipped, see CompressionOf. defaultFormat is returned if there is no such extension
+func TileFormatOf(file string, defaultFormat TileFormat) TileFormat {
+	file = TrimCompression(file)
+	ext := path.Ext(strings.TrimSuffix(file, path.Ext(file)))
+	for format, name := range tileFormatNames {
+		if ext == "."+name {
+			return format
+		}
+	}
+	return defaultFormat
+}
+
+// Compression of binary tile data files
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	// PackBits run-length encoding
+	CompressionRLE
+	// PB16, used by Game Boy homebrew
+	CompressionPB16
+	// LZSS in the format of the LZ77 functions of the GBA BIOS
+	CompressionLZSS
+)
+
+var compressionNames = map[Compression]string{
+	CompressionNone: "none",
+	CompressionRLE:  "rle",
+	CompressionPB16: "pb16",
+	CompressionLZSS: "lzss",
+}
+
+func (c Compression) String() string {
+	return compressionNames[c]
+}
+
+// Extension appended to names of compressed files, e.g. .pb16 for tiles.chr.pb16. Empty for uncompressed files
+func (c Compression) Extension() string {
+	if c == CompressionNone {
+		return ""
+	}
+	return "." + c.String()
 }
 
+// CompressionOf returns the compression named by the last extension of the file, e.g. tiles.chr.pb16.
+// defaultCompression is returned if there is no such extension
+func CompressionOf(file string, defaultCompression Compression) Compression {
+	ext := path.Ext(file)
+	for compression := range compressionNames {
+		if compression != CompressionNone && ext == compression.Extension() {
+			return compression
+		}
+	}
+	return defaultCompression
+}
+
+// TrimCompression removes the compression extension from the file name
+func TrimCompression(file string) string {
+	return strings.TrimSuffix(file, CompressionOf(file, CompressionNone).Extension())
+}
+
+type Compile struct {
+	Image string
+	Name  string
+	Type  CompileType
+	// Treat flipped copies of tiles as duplicates, only used for metatiles
+	DetectFlips bool
+	Format      MetatileFormat
+	TileFormat  TileFormat
+	Compression Compression
+}
+
+type CompileType uint8
+
+const (
+	CompileTiles CompileType = iota
+	CompileMetatiles
+)
+
+// Inclusive range of tile indexes
 type IndexRange struct {
 	Start, End uint8
 }
 
+func (r IndexRange) Overlaps(rhs IndexRange) bool {
+	return r.Start <= rhs.End && rhs.Start <= r.End
+}
+
+func (r IndexRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%x", r.Start)
+	}
+	return fmt.Sprintf("%x:%x", r.Start, r.End)
+}
+
+// Inclusive range of tile indexes in a VRAM bank
+type BankRange struct {
+	Range IndexRange
+	Bank  uint8
+}
+
+// Tiles are stored row by row
 type Metatile struct {
-	TopLeft     uint8
-	TopRight    uint8
-	BottomLeft  uint8
-	BottomRight uint8
+	Tiles []uint8
+	// Either empty or attributes for every tile
+	Attributes []TileAttributes
+	// Custom properties, such as collision
+	Properties []Property
+}
+
+func (m *Metatile) GetAttributes(i int) TileAttributes {
+	if i >= len(m.Attributes) {
+		return 0
+	}
+	return m.Attributes[i]
+}
+
+// Custom property of a metatile, kept as a tile property in Tiled tilesets
+type Property struct {
+	Name string
+	Type PropertyType
+	// Value in text form, e.g. "true" or "1.5"
+	Value string
+}
+
+type PropertyType uint8
+
+const (
+	PropertyString PropertyType = iota
+	PropertyInt
+	PropertyFloat
+	PropertyBool
+)
+
+// CGB BG map attributes
+type TileAttributes uint8
+
+const (
+	AttrPaletteMask TileAttributes = 0x07
+	AttrBank        TileAttributes = 1 << 3
+	AttrPriority    TileAttributes = 1 << 7
+)
+
+func (a TileAttributes) Palette() uint8 {
+	return uint8(a & AttrPaletteMask)
+}
+
+func (a TileAttributes) Bank() uint8 {
+	if a&AttrBank != 0 {
+		return 1
+	}
+	return 0
+}
+
+func (a TileAttributes) Flip() TileFlip {
+	return TileFlip(a) & FlipXY
+}
+
+func (a TileAttributes) Priority() bool {
+	return a&AttrPriority != 0
+}
+
+type TileFlip uint8
+
+// Flip bits match the X/Y flip bits of the CGB BG map attributes
+const (
+	FlipX TileFlip = 1 << (iota + 5)
+	FlipY
+	FlipXY = FlipX | FlipY
+)
+
+// Remapping of a source tile to the deduplicated tile set
+type TileRemap struct {
+	Index int
+	Flip  TileFlip
 }
 
 type TileRef struct {
 	File   string
 	Range  IndexRange
 	Offset uint8
+	// VRAM bank, only used with CGB attributes
+	Bank uint8
 }
 
+// Less orders refs by bank and then by start of the range
 func (r *TileRef) Less(rhs *TileRef) bool {
-	return r.Range.Start < rhs.Range.Start && r.Range.End < rhs.Range.End
+	if r.Bank != rhs.Bank {
+		return r.Bank < rhs.Bank
+	}
+	return r.Range.Start < rhs.Range.Start
 }
 
 func (r *TileRef) InRange(index uint8) bool {
@@ -109,15 +453,50 @@ type Tiles struct {
 
 type Metatiles struct {
 	Palette     []color.Color
-	Refs        Tree[TileRef]
-	AbsentTiles Tree[IndexRange]
+	CGBPalettes [][]color.Color
+	Refs        TileRefs
+	AbsentTiles Tree[BankRange]
 	Metatiles   []Metatile
+	Size        MetatileSize
+	Addressing  AddressingMode
 }
 
 func NewMetatiles() *Metatiles {
 	return &Metatiles{
-		Refs:        NewTree(func(lhs, rhs *TileRef) bool { return lhs.Less(rhs) }),
-		AbsentTiles: NewTree(func(lhs, rhs *IndexRange) bool { return lhs.Start < rhs.Start && lhs.End < rhs.End }),
+		Size: MetatileSize{Width: DefaultMetatileSize, Height: DefaultMetatileSize},
+		Refs: NewTileRefs(),
+		AbsentTiles: NewTree(func(lhs, rhs *BankRange) bool {
+			if lhs.Bank != rhs.Bank {
+				return lhs.Bank < rhs.Bank
+			}
+			return lhs.Range.Start < rhs.Range.Start && lhs.Range.End < rhs.Range.End
+		}),
+	}
+}
+
+// Background or level map
+type TileMap struct {
+	Width, Height int
+	// Metatile indexes if Metatiles is set, tile indexes otherwise, row by row
+	Cells []uint8
+	// CGB attributes of the cells, only used for tile maps
+	Attributes  []TileAttributes
+	Metatiles   *Metatiles
+	Refs        TileRefs
+	Palette     []color.Color
+	CGBPalettes [][]color.Color
+	// Only used for tile maps
+	Addressing AddressingMode
+
+	// Source files, loaded by file_manager
+	MetatileFile  string
+	CellFile      string
+	AttributeFile string
+}
+
+func NewTileMap() *TileMap {
+	return &TileMap{
+		Refs: NewTileRefs(),
 	}
 }
 
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"fmt"
+	"iter"
+)
+
+// TileRefs maps tile indexes to tile data files. Ranges are inclusive, refs of the same VRAM bank never overlap
+type TileRefs struct {
+	tree Tree[TileRef]
+}
+
+func NewTileRefs() TileRefs {
+	return TileRefs{tree: NewTree(func(lhs, rhs *TileRef) bool { return lhs.Less(rhs) })}
+}
+
+// OverlapError is returned when a ref overlaps a ref which is already present
+type OverlapError struct {
+	Ref      TileRef
+	Existing TileRef
+}
+
+func (e *OverlapError) Error() string {
+	return fmt.Sprintf("range %s overlaps range %s of %s", e.Ref.Range, e.Existing.Range, e.Existing.File)
+}
+
+// Insert adds the ref, refs overlapping already present refs of the same bank are rejected with *OverlapError
+func (r *TileRefs) Insert(ref TileRef) error {
+	if ref.Range.Start > ref.Range.End {
+		return fmt.Errorf("invalid range %s", ref.Range)
+	}
+	if existing := r.overlapping(ref); existing != nil {
+		return &OverlapError{Ref: ref, Existing: existing.GetValue()}
+	}
+	r.tree.Insert(ref)
+	return nil
+}
+
+// Override adds the ref, parts of overlapping refs of the same bank covered by it are removed
+func (r *TileRefs) Override(ref TileRef) error {
+	if ref.Range.Start > ref.Range.End {
+		return fmt.Errorf("invalid range %s", ref.Range)
+	}
+	for node := r.overlapping(ref); node != nil; node = r.overlapping(ref) {
+		existing := node.GetValue()
+		r.tree.Delete(existing)
+		// The remaining parts don't overlap ref, so the loop ends once all overlapping refs are split
+		if existing.Range.Start < ref.Range.Start {
+			left := existing
+			left.Range.End = ref.Range.Start - 1
+			r.tree.Insert(left)
+		}
+		if existing.Range.End > ref.Range.End {
+			right := existing
+			right.Range.Start = ref.Range.End + 1
+			right.Offset += right.Range.Start - existing.Range.Start
+			r.tree.Insert(right)
+		}
+	}
+	r.tree.Insert(ref)
+	return nil
+}
+
+// Delete removes the ref with the same bank and start of the range, returns false if there is none
+func (r *TileRefs) Delete(ref TileRef) bool {
+	return r.tree.Delete(ref)
+}
+
+// Find returns the ref containing the index
+func (r *TileRefs) Find(bank, index uint8) (TileRef, bool) {
+	node := r.tree.floor(TileRef{Range: IndexRange{Start: index, End: index}, Bank: bank})
+	if node == nil || node.value.Bank != bank || !node.value.InRange(index) {
+		return TileRef{}, false
+	}
+	return node.value, true
+}
+
+func (r *TileRefs) Size() int {
+	return r.tree.Size()
+}
+
+// Begin returns the first ref, refs are ordered by bank and then by start of the range
+func (r *TileRefs) Begin() *Node[TileRef] {
+	return r.tree.Begin()
+}
+
+// All iterates over refs ordered by bank and then by start of the range
+func (r *TileRefs) All() iter.Seq[TileRef] {
+	return r.tree.All()
+}
+
+// overlapping returns a ref of the same bank overlapping ref or nil
+func (r *TileRefs) overlapping(ref TileRef) *Node[TileRef] {
+	prev := r.tree.floor(ref)
+	if prev != nil && prev.value.Bank == ref.Bank && prev.value.Range.Overlaps(ref.Range) {
+		return prev
+	}
+
+	next := r.tree.Begin()
+	if prev != nil {
+		next = prev.Next()
+	}
+	if next != nil && next.value.Bank == ref.Bank && next.value.Range.Overlaps(ref.Range) {
+		return next
+	}
+	return nil
+}
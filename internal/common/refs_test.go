@@ -0,0 +1,79 @@
+package common
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func refRanges(refs TileRefs) []TileRef {
+	return append([]TileRef{}, slices.Collect(refs.All())...)
+}
+
+func TestTileRefsInsert(t *testing.T) {
+	refs := NewTileRefs()
+	assert.NoError(t, refs.Insert(TileRef{File: "a.chr", Range: IndexRange{0x10, 0x1f}}))
+	assert.NoError(t, refs.Insert(TileRef{File: "b.chr", Range: IndexRange{0x20, 0x20}}))
+	assert.NoError(t, refs.Insert(TileRef{File: "c.chr", Range: IndexRange{0, 0xff}, Bank: 1}))
+	assert.Error(t, refs.Insert(TileRef{File: "d.chr", Range: IndexRange{2, 1}}))
+
+	for _, rng := range []IndexRange{{0, 0xff}, {0, 0x10}, {0x1f, 0x1f}, {0x15, 0x16}, {0x20, 0x30}} {
+		err := refs.Insert(TileRef{File: "e.chr", Range: rng})
+		var overlap *OverlapError
+		assert.ErrorAs(t, err, &overlap, "range %s", rng)
+	}
+	assert.Equal(t, 3, refs.Size())
+
+	ref, ok := refs.Find(0, 0x15)
+	assert.True(t, ok)
+	assert.Equal(t, "a.chr", ref.File)
+	ref, ok = refs.Find(0, 0x20)
+	assert.True(t, ok)
+	assert.Equal(t, "b.chr", ref.File)
+	ref, ok = refs.Find(1, 0x15)
+	assert.True(t, ok)
+	assert.Equal(t, "c.chr", ref.File)
+	_, ok = refs.Find(0, 0x21)
+	assert.False(t, ok)
+	_, ok = refs.Find(0, 0)
+	assert.False(t, ok)
+}
+
+func TestTileRefsOverride(t *testing.T) {
+	refs := NewTileRefs()
+	assert.NoError(t, refs.Insert(TileRef{File: "a.chr", Range: IndexRange{0, 0x7f}}))
+	assert.NoError(t, refs.Insert(TileRef{File: "b.chr", Range: IndexRange{0, 0x7f}, Bank: 1}))
+	assert.NoError(t, refs.Override(TileRef{File: "empty.chr", Range: IndexRange{0x10, 0x10}}))
+
+	assert.Equal(t, []TileRef{
+		{File: "a.chr", Range: IndexRange{0, 0xf}},
+		{File: "empty.chr", Range: IndexRange{0x10, 0x10}},
+		{File: "a.chr", Range: IndexRange{0x11, 0x7f}, Offset: 0x11},
+		{File: "b.chr", Range: IndexRange{0, 0x7f}, Bank: 1},
+	}, refRanges(refs))
+
+	assert.NoError(t, refs.Override(TileRef{File: "c.chr", Range: IndexRange{0x8, 0x20}}))
+	assert.Equal(t, []TileRef{
+		{File: "a.chr", Range: IndexRange{0, 0x7}},
+		{File: "c.chr", Range: IndexRange{0x8, 0x20}},
+		{File: "a.chr", Range: IndexRange{0x21, 0x7f}, Offset: 0x21},
+		{File: "b.chr", Range: IndexRange{0, 0x7f}, Bank: 1},
+	}, refRanges(refs))
+}
+
+func TestTileRefsDelete(t *testing.T) {
+	refs := NewTileRefs()
+	assert.NoError(t, refs.Insert(TileRef{File: "a.chr", Range: IndexRange{0, 0xf}}))
+	assert.NoError(t, refs.Insert(TileRef{File: "b.chr", Range: IndexRange{0x10, 0x1f}}))
+
+	assert.False(t, refs.Delete(TileRef{Range: IndexRange{0x10, 0x10}, Bank: 1}))
+	assert.True(t, refs.Delete(TileRef{Range: IndexRange{0x10, 0x10}}))
+	_, ok := refs.Find(0, 0x10)
+	assert.False(t, ok)
+	assert.NoError(t, refs.Insert(TileRef{File: "c.chr", Range: IndexRange{0x10, 0x2f}}))
+	assert.Equal(t, []TileRef{
+		{File: "a.chr", Range: IndexRange{0, 0xf}},
+		{File: "c.chr", Range: IndexRange{0x10, 0x2f}},
+	}, refRanges(refs))
+}
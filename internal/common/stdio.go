@@ -0,0 +1,102 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// StdStream is used instead of a file path to read from stdin or write to stdout
+const StdStream = "-"
+
+var (
+	stdinData []byte
+	stdinErr  error
+	stdinOnce sync.Once
+)
+
+// ReadFile reads the whole file or stdin if path is StdStream.
+// Stdin is only read once, so the same data is returned on every call
+func ReadFile(path string) ([]byte, error) {
+	if path != StdStream {
+		return os.ReadFile(path)
+	}
+
+	stdinOnce.Do(func() {
+		stdinData, stdinErr = io.ReadAll(os.Stdin)
+	})
+	return stdinData, stdinErr
+}
+
+// WriteFile writes data to the file or to stdout if path is StdStream
+func WriteFile(path string, data []byte) error {
+	if path != StdStream {
+		return os.WriteFile(path, data, 0666)
+	}
+
+	_, err := os.Stdout.Write(data)
+	return err
+}
+
+// FileStamp is used to detect changes of a file without reading it
+type FileStamp struct {
+	Exists  bool
+	ModTime time.Time
+	Size    int64
+}
+
+// NewFileStamp returns the zero value for files which don't exist or can't be accessed
+func NewFileStamp(file string) FileStamp {
+	info, err := os.Stat(file)
+	if err != nil {
+		return FileStamp{}
+	}
+	return FileStamp{Exists: true, ModTime: info.ModTime(), Size: info.Size()}
+}
+
+// HostFS opens files by their paths in the host file system, StdStream opens stdin, see ReadFile.
+// Unlike os.DirFS, absolute paths and paths outside of the working directory are accepted
+var HostFS fs.FS = hostFS{}
+
+type hostFS struct{}
+
+func (hostFS) Open(name string) (fs.File, error) {
+	if name != StdStream {
+		return os.Open(name)
+	}
+	data, err := ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return stdinFile{bytes.NewReader(data)}, nil
+}
+
+func (hostFS) ReadFile(name string) ([]byte, error) {
+	return ReadFile(name)
+}
+
+type stdinFile struct {
+	*bytes.Reader
+}
+
+func (f stdinFile) Stat() (fs.FileInfo, error) {
+	return stdinInfo{size: f.Size()}, nil
+}
+
+func (stdinFile) Close() error {
+	return nil
+}
+
+type stdinInfo struct {
+	size int64
+}
+
+func (stdinInfo) Name() string       { return StdStream }
+func (i stdinInfo) Size() int64      { return i.size }
+func (stdinInfo) Mode() fs.FileMode  { return 0 }
+func (stdinInfo) ModTime() time.Time { return time.Time{} }
+func (stdinInfo) IsDir() bool        { return false }
+func (stdinInfo) Sys() any           { return nil }
@@ -1,5 +1,7 @@
 package common
 
+import "iter"
+
 type nodeColor bool
 
 const (
@@ -40,6 +42,27 @@ func (n *Node[T]) Next() *Node[T] {
 	}
 }
 
+// Prev returns the previous node in order or nil
+func (n *Node[T]) Prev() *Node[T] {
+	switch {
+	case n == nil:
+		return nil
+	case n.getLeft() != nil:
+		prev := n.getLeft()
+		for right := prev.getRight(); right != nil; right = prev.getRight() {
+			prev = right
+		}
+		return prev
+	default:
+		prev := n
+		for parent := prev.getParent(); parent != nil && prev == parent.getLeft(); parent = prev.getParent() {
+			prev = parent
+		}
+		return prev.getParent()
+	}
+}
+
+// Tree is a red-black tree ordered by less, values which are not less than each other are equivalent
 type Tree[T any] struct {
 	root *Node[T]
 	size int
@@ -89,7 +112,11 @@ func (t *Tree[T]) Find(value T) *Node[T] {
 	return nil
 }
 
+// Begin returns the first node or nil if the tree is empty
 func (t *Tree[T]) Begin() *Node[T] {
+	if t.root == nil {
+		return nil
+	}
 	begin := t.root
 	for left := begin.getLeft(); left != nil; left = begin.getLeft() {
 		begin = left
@@ -98,6 +125,96 @@ func (t *Tree[T]) Begin() *Node[T] {
 	return begin
 }
 
+// Last returns the last node or nil if the tree is empty
+func (t *Tree[T]) Last() *Node[T] {
+	if t.root == nil {
+		return nil
+	}
+	last := t.root
+	for right := last.getRight(); right != nil; right = last.getRight() {
+		last = right
+	}
+
+	return last
+}
+
+// LowerBound returns the first node not less than value or nil
+func (t *Tree[T]) LowerBound(value T) *Node[T] {
+	var result *Node[T]
+	for x := t.root; x != nil; {
+		if t.less(&x.value, &value) {
+			x = x.right
+		} else {
+			result = x
+			x = x.left
+		}
+	}
+	return result
+}
+
+// UpperBound returns the first node greater than value or nil
+func (t *Tree[T]) UpperBound(value T) *Node[T] {
+	var result *Node[T]
+	for x := t.root; x != nil; {
+		if t.less(&value, &x.value) {
+			result = x
+			x = x.left
+		} else {
+			x = x.right
+		}
+	}
+	return result
+}
+
+// All iterates over values in order
+func (t *Tree[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for node := t.Begin(); node != nil; node = node.Next() {
+			if !yield(node.value) {
+				return
+			}
+		}
+	}
+}
+
+// Backward iterates over values in reverse order
+func (t *Tree[T]) Backward() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for node := t.Last(); node != nil; node = node.Prev() {
+			if !yield(node.value) {
+				return
+			}
+		}
+	}
+}
+
+// Range iterates over values in [lo, hi) in order
+func (t *Tree[T]) Range(lo, hi T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for node := t.LowerBound(lo); node != nil && t.less(&node.value, &hi); node = node.Next() {
+			if !yield(node.value) {
+				return
+			}
+		}
+	}
+}
+
+// Delete removes a value equivalent to value, returns false if there is none
+func (t *Tree[T]) Delete(value T) bool {
+	node := t.Find(value)
+	if node == nil {
+		return false
+	}
+	t.deleteNode(node)
+	return true
+}
+
+// Clear removes all values
+func (t *Tree[T]) Clear() {
+	t.root = nil
+	t.size = 0
+}
+
 func (n *Node[T]) getParent() *Node[T] {
 	if n == nil {
 		return nil
@@ -202,6 +319,118 @@ func (t *Tree[T]) fixInsert(node *Node[T]) {
 	t.root.color = black
 }
 
+// replace puts v in place of u in u's parent
+func (t *Tree[T]) replace(u, v *Node[T]) {
+	switch {
+	case u.parent == nil:
+		t.root = v
+	case u == u.parent.left:
+		u.parent.left = v
+	default:
+		u.parent.right = v
+	}
+	if v != nil {
+		v.parent = u.parent
+	}
+}
+
+func (t *Tree[T]) deleteNode(z *Node[T]) {
+	removedColor := z.color
+	//x takes place of the removed node, it might be nil, so its parent is tracked separately
+	var x, xParent *Node[T]
+	switch {
+	case z.left == nil:
+		x, xParent = z.right, z.parent
+		t.replace(z, z.right)
+	case z.right == nil:
+		x, xParent = z.left, z.parent
+		t.replace(z, z.left)
+	default:
+		//y is the successor of z, it has no left child
+		y := z.right
+		for y.left != nil {
+			y = y.left
+		}
+		removedColor = y.color
+		x = y.right
+		if y.parent == z {
+			xParent = y
+		} else {
+			xParent = y.parent
+			t.replace(y, y.right)
+			y.right = z.right
+			y.right.parent = y
+		}
+		t.replace(z, y)
+		y.left = z.left
+		y.left.parent = y
+		y.color = z.color
+	}
+
+	if removedColor == black {
+		t.fixDelete(x, xParent)
+	}
+	z.left, z.right, z.parent = nil, nil, nil
+	t.size--
+}
+
+func (t *Tree[T]) fixDelete(x, parent *Node[T]) {
+	for x != t.root && x.getColor() == black {
+		//x is doubly black, so its sibling w is not nil
+		if x == parent.left {
+			w := parent.right
+			if w.color == red {
+				w.color = black
+				parent.color = red
+				t.rotateLeft(parent)
+				w = parent.right
+			}
+			if w.getLeft().getColor() == black && w.getRight().getColor() == black {
+				w.color = red
+				x, parent = parent, parent.parent
+				continue
+			}
+			if w.getRight().getColor() == black {
+				w.left.color = black
+				w.color = red
+				t.rotateRight(w)
+				w = parent.right
+			}
+			w.color = parent.color
+			parent.color = black
+			w.right.color = black
+			t.rotateLeft(parent)
+		} else {
+			w := parent.left
+			if w.color == red {
+				w.color = black
+				parent.color = red
+				t.rotateRight(parent)
+				w = parent.left
+			}
+			if w.getLeft().getColor() == black && w.getRight().getColor() == black {
+				w.color = red
+				x, parent = parent, parent.parent
+				continue
+			}
+			if w.getLeft().getColor() == black {
+				w.right.color = black
+				w.color = red
+				t.rotateLeft(w)
+				w = parent.left
+			}
+			w.color = parent.color
+			parent.color = black
+			w.left.color = black
+			t.rotateRight(parent)
+		}
+		x = t.root
+	}
+	if x != nil {
+		x.color = black
+	}
+}
+
 func (t *Tree[T]) getInsertionPlace(value T) *Node[T] {
 
 	var y *Node[T]
@@ -216,3 +445,17 @@ func (t *Tree[T]) getInsertionPlace(value T) *Node[T] {
 
 	return y
 }
+
+// floor returns the greatest node not greater than value or nil
+func (t *Tree[T]) floor(value T) *Node[T] {
+	var result *Node[T]
+	for x := t.root; x != nil; {
+		if t.less(&value, &x.value) {
+			x = x.left
+		} else {
+			result = x
+			x = x.right
+		}
+	}
+	return result
+}
@@ -1,13 +1,16 @@
 package common
 
 import (
+	"math/rand"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestTree(t *testing.T) {
-	tree := NewTree(func(lhs, rhs *TileRef) bool { return lhs.Less(rhs) })
+	// Disjoint ranges are ordered, overlapping ranges are equivalent
+	tree := NewTree(func(lhs, rhs *TileRef) bool { return lhs.Range.End < rhs.Range.Start })
 	values := []TileRef{
 		{Range: IndexRange{1, 3}},
 		{Range: IndexRange{4, 6}},
@@ -30,6 +33,7 @@ func TestTree(t *testing.T) {
 		}
 
 		assert.Equal(t, len(values), tree.Size(), "wrong size")
+		checkTree(t, &tree)
 	})
 
 	t.Run("find", func(t *testing.T) {
@@ -75,6 +79,123 @@ func TestTree(t *testing.T) {
 	})
 }
 
+// checkTree checks the red-black properties, parent links, order and size of the tree
+func checkTree[T any](t *testing.T, tree *Tree[T]) {
+	t.Helper()
+	if tree.root == nil {
+		assert.Equal(t, 0, tree.Size(), "empty tree has non-zero size")
+		return
+	}
+	assert.Nil(t, tree.root.parent, "root has a parent")
+	assert.Equal(t, black, tree.root.color, "root is red")
+	count, _ := checkNode(t, tree, tree.root)
+	assert.Equal(t, count, tree.Size(), "wrong size")
+
+	values := slices.Collect(tree.All())
+	for i := 1; i < len(values); i++ {
+		assert.True(t, tree.less(&values[i-1], &values[i]), "values are out of order at %d", i)
+	}
+}
+
+// checkNode returns the number of nodes and the black height of the subtree
+func checkNode[T any](t *testing.T, tree *Tree[T], node *Node[T]) (count int, height int) {
+	t.Helper()
+	if node == nil {
+		return 0, 1
+	}
+	for _, child := range []*Node[T]{node.left, node.right} {
+		if child == nil {
+			continue
+		}
+		assert.Same(t, node, child.parent, "wrong parent link")
+		if node.color == red {
+			assert.Equal(t, black, child.color, "red node has a red child")
+		}
+	}
+	if node.left != nil {
+		assert.True(t, tree.less(&node.left.value, &node.value), "left child is not less than its parent")
+	}
+	if node.right != nil {
+		assert.True(t, tree.less(&node.value, &node.right.value), "right child is not greater than its parent")
+	}
+
+	leftCount, leftHeight := checkNode(t, tree, node.left)
+	rightCount, rightHeight := checkNode(t, tree, node.right)
+	assert.Equal(t, leftHeight, rightHeight, "black heights differ")
+	if node.color == black {
+		leftHeight++
+	}
+	return leftCount + rightCount + 1, leftHeight
+}
+
+func TestTreeDelete(t *testing.T) {
+	tree := NewTree(func(lhs, rhs *int) bool { return *lhs < *rhs })
+	rng := rand.New(rand.NewSource(1))
+	present := map[int]bool{}
+	for i := 0; i < 2000; i++ {
+		val := rng.Intn(300)
+		if rng.Intn(3) == 0 {
+			assert.Equal(t, present[val], tree.Delete(val), "delete %d", val)
+			delete(present, val)
+		} else if !present[val] {
+			tree.Insert(val)
+			present[val] = true
+		}
+		if i%100 == 0 {
+			checkTree(t, &tree)
+		}
+	}
+	checkTree(t, &tree)
+	assert.Equal(t, len(present), tree.Size())
+
+	for val := range present {
+		assert.True(t, tree.Delete(val))
+	}
+	checkTree(t, &tree)
+	assert.Nil(t, tree.Begin())
+	assert.Nil(t, tree.Last())
+	assert.False(t, tree.Delete(0))
+}
+
+func TestTreeBounds(t *testing.T) {
+	tree := NewTree(func(lhs, rhs *int) bool { return *lhs < *rhs })
+	for _, val := range []int{10, 20, 30, 40, 50} {
+		tree.Insert(val)
+	}
+	checkTree(t, &tree)
+
+	assert.Equal(t, 10, tree.LowerBound(5).GetValue())
+	assert.Equal(t, 20, tree.LowerBound(20).GetValue())
+	assert.Equal(t, 30, tree.UpperBound(20).GetValue())
+	assert.Equal(t, 30, tree.UpperBound(25).GetValue())
+	assert.Nil(t, tree.LowerBound(51))
+	assert.Nil(t, tree.UpperBound(50))
+
+	assert.Equal(t, []int{10, 20, 30, 40, 50}, slices.Collect(tree.All()))
+	assert.Equal(t, []int{50, 40, 30, 20, 10}, slices.Collect(tree.Backward()))
+	assert.Equal(t, []int{20, 30}, slices.Collect(tree.Range(15, 40)))
+	assert.Empty(t, slices.Collect(tree.Range(41, 50)))
+
+	var reversed []int
+	for node := tree.Last(); node != nil; node = node.Prev() {
+		reversed = append(reversed, node.GetValue())
+	}
+	assert.Equal(t, []int{50, 40, 30, 20, 10}, reversed)
+
+	for val := range tree.All() {
+		if val == 30 {
+			break
+		}
+		reversed = reversed[:len(reversed)-1]
+	}
+	assert.Equal(t, []int{50, 40, 30}, reversed)
+
+	tree.Clear()
+	checkTree(t, &tree)
+	assert.Nil(t, tree.Begin())
+	assert.Empty(t, slices.Collect(tree.All()))
+}
+
 func makeValues[T any](b *testing.B, getter func(int) T) []T {
 	b.StopTimer()
 	b.ResetTimer()
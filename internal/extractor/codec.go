@@ -0,0 +1,143 @@
+package extractor
+
+import "github.com/Onlymiind/tileset_manager/internal/common"
+
+// Codec converts tiles between a binary tile format and color indexes of their pixels
+type Codec interface {
+	// Number of bits of a color index, tiles have at most 1 << BitsPerPixel() colors
+	BitsPerPixel() int
+	// Size of an encoded tile
+	BytesPerTile() int
+	// DecodeTile converts an encoded tile to color indexes, row by row. Returns nil if src has the wrong size
+	DecodeTile(src []byte) []byte
+	// EncodeTile converts color indexes of a tile to the binary format, extra bits of the indexes are dropped.
+	// Returns nil if tile has the wrong size
+	EncodeTile(tile []byte) []byte
+}
+
+var codecs = map[common.TileFormat]Codec{
+	common.TileFormatGB:    planarCodec{bpp: 2, offset: interleavedPlanes},
+	common.TileFormatNES:   planarCodec{bpp: 2, offset: sequentialPlanes},
+	common.TileFormatSNES4: planarCodec{bpp: 4, offset: interleavedPlanes},
+	common.TileFormatPCE:   planarCodec{bpp: 4, offset: interleavedPlanes},
+	common.TileFormatSNES8: planarCodec{bpp: 8, offset: interleavedPlanes},
+	common.TileFormatGBA4:  linearCodec{bpp: 4},
+	common.TileFormatGBA8:  linearCodec{bpp: 8},
+	common.TileFormat1bpp:  planarCodec{bpp: 1, offset: sequentialPlanes},
+}
+
+// CodecFor returns the codec of the format, unknown formats are treated as Game Boy tiles
+func CodecFor(format common.TileFormat) Codec {
+	if codec, ok := codecs[format]; ok {
+		return codec
+	}
+	return codecs[common.TileFormatGB]
+}
+
+// planarCodec stores bit k of the color indexes of a row in plane k, one byte per row of a plane.
+// The leftmost pixel is the most significant bit
+type planarCodec struct {
+	bpp int
+	// offset returns position of the byte with the row of the plane
+	offset func(plane, row int) int
+}
+
+// Pairs of planes are interleaved row by row: row 0 of plane 0, row 0 of plane 1, row 1 of plane 0 and so on.
+// Used by the Game Boy, SNES and PC Engine
+func interleavedPlanes(plane, row int) int {
+	return plane/2*2*common.TileSizePx + row*2 + plane%2
+}
+
+// Planes are stored one after another, used by the NES
+func sequentialPlanes(plane, row int) int {
+	return plane*common.TileSizePx + row
+}
+
+func (c planarCodec) BitsPerPixel() int {
+	return c.bpp
+}
+
+func (c planarCodec) BytesPerTile() int {
+	return c.bpp * common.TileSizePx
+}
+
+func (c planarCodec) DecodeTile(src []byte) []byte {
+	if len(src) != c.BytesPerTile() {
+		return nil
+	}
+
+	result := make([]byte, common.BitsPerTile)
+	for y := 0; y < common.TileSizePx; y++ {
+		row := result[y*common.TileSizePx : (y+1)*common.TileSizePx]
+		for plane := 0; plane < c.bpp; plane++ {
+			bits := src[c.offset(plane, y)]
+			for x := range row {
+				row[x] |= bits >> (common.TileSizePx - 1 - x) & 1 << plane
+			}
+		}
+	}
+
+	return result
+}
+
+func (c planarCodec) EncodeTile(tile []byte) []byte {
+	if len(tile) != common.BitsPerTile {
+		return nil
+	}
+
+	result := make([]byte, c.BytesPerTile())
+	for y := 0; y < common.TileSizePx; y++ {
+		row := tile[y*common.TileSizePx : (y+1)*common.TileSizePx]
+		for plane := 0; plane < c.bpp; plane++ {
+			var bits byte
+			for _, pixel := range row {
+				bits = bits<<1 | pixel>>plane&1
+			}
+			result[c.offset(plane, y)] = bits
+		}
+	}
+
+	return result
+}
+
+// linearCodec stores color indexes row by row, 8 / bpp pixels per byte starting from the least significant bits.
+// Used by the GBA
+type linearCodec struct {
+	bpp int
+}
+
+func (c linearCodec) BitsPerPixel() int {
+	return c.bpp
+}
+
+func (c linearCodec) BytesPerTile() int {
+	return common.BitsPerTile * c.bpp / 8
+}
+
+func (c linearCodec) DecodeTile(src []byte) []byte {
+	if len(src) != c.BytesPerTile() {
+		return nil
+	}
+
+	mask := byte(1<<c.bpp - 1)
+	result := make([]byte, common.BitsPerTile)
+	for i := range result {
+		result[i] = src[i*c.bpp/8] >> (i * c.bpp % 8) & mask
+	}
+
+	return result
+}
+
+func (c linearCodec) EncodeTile(tile []byte) []byte {
+	if len(tile) != common.BitsPerTile {
+		return nil
+	}
+
+	mask := byte(1<<c.bpp - 1)
+	result := make([]byte, c.BytesPerTile())
+	for i, pixel := range tile {
+		result[i*c.bpp/8] |= pixel & mask << (i * c.bpp % 8)
+	}
+
+	return result
+}
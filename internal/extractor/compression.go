@@ -0,0 +1,250 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+)
+
+// Compressor converts whole binary tile data files to and from a compressed format
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	// Decompress returns an error if data is truncated or invalid
+	Decompress(data []byte) ([]byte, error)
+}
+
+var compressors = map[common.Compression]Compressor{
+	common.CompressionNone: noCompression{},
+	common.CompressionRLE:  runLength{},
+	common.CompressionPB16: pb16{},
+	common.CompressionLZSS: lzss{},
+}
+
+// CompressorFor returns the compressor of the compression, unknown compressions leave data as is
+func CompressorFor(compression common.Compression) Compressor {
+	if compressor, ok := compressors[compression]; ok {
+		return compressor
+	}
+	return noCompression{}
+}
+
+type noCompression struct{}
+
+func (noCompression) Compress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (noCompression) Decompress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// runLength is the PackBits run-length encoding. Header byte n is followed by n+1 literal bytes for n in 0..127
+// or by a single byte repeated 1-n times for n in -127..-1, n = -128 is skipped
+type runLength struct{}
+
+const maxRunLength = 128
+
+func (runLength) Compress(data []byte) ([]byte, error) {
+	var result []byte
+	literals := 0
+	flush := func(end int) {
+		for literals < end {
+			count := min(end-literals, maxRunLength)
+			result = append(result, byte(count-1))
+			result = append(result, data[literals:literals+count]...)
+			literals += count
+		}
+	}
+
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && run < maxRunLength && data[i+run] == data[i] {
+			run++
+		}
+		// Runs of two bytes are cheaper as a part of literals
+		if run >= 3 {
+			flush(i)
+			result = append(result, byte(1-run), data[i])
+			literals = i + run
+		}
+		i += run
+	}
+	flush(len(data))
+	return result, nil
+}
+
+func (runLength) Decompress(data []byte) ([]byte, error) {
+	var result []byte
+	for i := 0; i < len(data); {
+		header := int8(data[i])
+		i++
+		switch {
+		case header >= 0:
+			count := int(header) + 1
+			if i+count > len(data) {
+				return nil, errors.New("unexpected end of rle data")
+			}
+			result = append(result, data[i:i+count]...)
+			i += count
+		case header != -128:
+			if i >= len(data) {
+				return nil, errors.New("unexpected end of rle data")
+			}
+			for j := 0; j < 1-int(header); j++ {
+				result = append(result, data[i])
+			}
+			i++
+		}
+	}
+	return result, nil
+}
+
+// pb16 stores packets of 8 bytes preceded by a control byte. Bit 7-k of the control byte is set if byte k
+// of the packet repeats the byte two positions before it, which is the same row of the other plane in Game Boy tiles.
+// Otherwise the byte follows the control byte. Bytes before the data are 0.
+// Data is padded to a multiple of 8 bytes, which is the case for every tile format
+type pb16 struct{}
+
+const pb16PacketSize = 8
+
+func (pb16) Compress(data []byte) ([]byte, error) {
+	var result []byte
+	prev := [2]byte{}
+	for i := 0; i < len(data); i += pb16PacketSize {
+		control := len(result)
+		result = append(result, 0)
+		for j := 0; j < pb16PacketSize; j++ {
+			b := prev[j%2]
+			if i+j < len(data) {
+				b = data[i+j]
+			}
+			if b == prev[j%2] {
+				result[control] |= 0x80 >> j
+			} else {
+				result = append(result, b)
+				prev[j%2] = b
+			}
+		}
+	}
+	return result, nil
+}
+
+func (pb16) Decompress(data []byte) ([]byte, error) {
+	var result []byte
+	prev := [2]byte{}
+	for i := 0; i < len(data); {
+		control := data[i]
+		i++
+		for j := 0; j < pb16PacketSize; j++ {
+			if control&(0x80>>j) == 0 {
+				if i >= len(data) {
+					return nil, errors.New("unexpected end of pb16 data")
+				}
+				prev[j%2] = data[i]
+				i++
+			}
+			result = append(result, prev[j%2])
+		}
+	}
+	return result, nil
+}
+
+// lzss is the format of the LZ77UnComp functions of the GBA BIOS: a header with type 0x10 and 24-bit
+// decompressed size followed by blocks of a flag byte and 8 items. Set flag bits, starting from bit 7, mark
+// references of 3-18 bytes at distance 1-4096 stored as 2 bytes, other items are literal bytes.
+// References are at least 2 bytes back, so that the data can be decompressed to VRAM
+type lzss struct{}
+
+const (
+	lzssType       = 0x10
+	lzssHeaderSize = 4
+	lzssMinLength  = 3
+	lzssMaxLength  = 18
+	lzssMinDisp    = 2
+	lzssMaxDisp    = 4096
+	lzssMaxSize    = 1<<24 - 1
+)
+
+func (lzss) Compress(data []byte) ([]byte, error) {
+	if len(data) > lzssMaxSize {
+		return nil, fmt.Errorf("lzss data is limited to %d bytes, got %d", lzssMaxSize, len(data))
+	}
+	result := []byte{lzssType, byte(len(data)), byte(len(data) >> 8), byte(len(data) >> 16)}
+	flags, bit := 0, 8
+	for i := 0; i < len(data); bit++ {
+		if bit == 8 {
+			bit = 0
+			flags = len(result)
+			result = append(result, 0)
+		}
+
+		length, disp := lzssMatch(data, i)
+		if length < lzssMinLength {
+			result = append(result, data[i])
+			i++
+			continue
+		}
+		result[flags] |= 0x80 >> bit
+		result = append(result, byte((length-lzssMinLength)<<4|(disp-1)>>8), byte(disp-1))
+		i += length
+	}
+	// The BIOS reads compressed data by words
+	for len(result)%4 != 0 {
+		result = append(result, 0)
+	}
+	return result, nil
+}
+
+// lzssMatch returns the longest match for the data at pos
+func lzssMatch(data []byte, pos int) (length, disp int) {
+	for d := lzssMinDisp; d <= lzssMaxDisp && d <= pos; d++ {
+		l := 0
+		for l < lzssMaxLength && pos+l < len(data) && data[pos+l] == data[pos+l-d] {
+			l++
+		}
+		if l > length {
+			length, disp = l, d
+		}
+	}
+	return length, disp
+}
+
+func (lzss) Decompress(data []byte) ([]byte, error) {
+	if len(data) < lzssHeaderSize || data[0] != lzssType {
+		return nil, errors.New("not lzss data")
+	}
+	size := int(data[1]) | int(data[2])<<8 | int(data[3])<<16
+	result := make([]byte, 0, size)
+	for i := lzssHeaderSize; len(result) < size; {
+		if i >= len(data) {
+			return nil, errors.New("unexpected end of lzss data")
+		}
+		flags := data[i]
+		i++
+		for bit := 0; bit < 8 && len(result) < size; bit++ {
+			if flags&(0x80>>bit) == 0 {
+				if i >= len(data) {
+					return nil, errors.New("unexpected end of lzss data")
+				}
+				result = append(result, data[i])
+				i++
+				continue
+			}
+
+			if i+1 >= len(data) {
+				return nil, errors.New("unexpected end of lzss data")
+			}
+			length := int(data[i]>>4) + lzssMinLength
+			disp := (int(data[i]&0xf)<<8 | int(data[i+1])) + 1
+			i += 2
+			if disp > len(result) {
+				return nil, fmt.Errorf("lzss reference to %d bytes back at offset %d", disp, len(result))
+			}
+			for j := 0; j < length && len(result) < size; j++ {
+				result = append(result, result[len(result)-disp])
+			}
+		}
+	}
+	return result, nil
+}
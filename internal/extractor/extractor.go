@@ -1,107 +1,275 @@
 package extractor
 
 import (
+	"fmt"
 	"sort"
 
 	"github.com/Onlymiind/tileset_manager/internal/common"
 )
 
-// Convert two bytes of source data to an array of color indexes
-// lsb: byte with least significant bits of color index
-// msb: byte with most significant bits of color index
-// returns: slice of 8 color indexes
-func getColorIndexes(lsb byte, msb byte) []byte {
-	result := make([]byte, 8)
-	mask := byte(1) << 7
+// Decode binary tile data, incomplete trailing tiles are ignored
+func ExtractTileData(src []byte, format common.TileFormat) *common.Tiles {
+	codec := CodecFor(format)
+	tileSize := codec.BytesPerTile()
+	tileCount := len(src) / tileSize
 
-	for i := 0; i < 8; i++ {
-		result[i] = lsb&mask>>7 | msb&mask>>6
-
-		lsb <<= 1
-		msb <<= 1
+	result := &common.Tiles{
+		Data: make([][]byte, 0, tileCount),
+		Size: common.MemorySizeFrom(float64(tileCount)*common.BitsPerTile, common.Bytes),
+	}
+	for tile := 0; tile < tileCount; tile++ {
+		offset := tile * tileSize
+		result.Data = append(result.Data, codec.DecodeTile(src[offset:offset+tileSize]))
 	}
 
 	return result
 }
 
-func getTile(src []byte) []byte {
-	if len(src) != common.BytesPerTile {
+// Get position of a metatile's tile in binary metatile data
+// format: metatile data format
+// count: total number of metatiles
+// mtile: index of the metatile
+// tile: row-major index of the tile inside the metatile
+func getTileOffset(format common.MetatileFormat, count, mtile, tile int) int {
+	tileCount := format.Size.TileCount()
+	switch format.Layout {
+	case common.LayoutColumnMajor:
+		row, column := tile/format.Size.Width, tile%format.Size.Width
+		return mtile*tileCount + column*format.Size.Height + row
+	case common.LayoutPlanar:
+		return tile*count + mtile
+	default:
+		return mtile*tileCount + tile
+	}
+}
+
+// Decode binary metatile data
+// src: tile indexes
+// attributes: CGB attributes stored in the same format as tile indexes, may be nil
+// tileData: tile references used to detect absent tiles
+// format: binary data format
+// returns: decoded metatiles, nil if the data doesn't match the format
+func ExtractMetatileData(src []byte, attributes []byte, tileData common.TileRefs, format common.MetatileFormat) *common.Metatiles {
+	tileCount := format.Size.TileCount()
+	if tileCount <= 0 || len(src) < tileCount || len(src)%tileCount != 0 {
+		return nil
+	}
+	if attributes != nil && len(attributes) != len(src) {
 		return nil
 	}
 
-	result := make([]byte, 0, common.BitsPerTile)
-	for y := 0; y < common.TileSizePx; y++ {
-		result = append(result, getColorIndexes(src[y*2], src[y*2+1])...)
+	result := common.NewMetatiles()
+
+	result.Refs = tileData
+	result.Size = format.Size
+	result.Addressing = format.Addressing
+
+	absent := map[common.BankRange]struct{}{}
+	count := len(src) / tileCount
+	for i := 0; i < count; i++ {
+		mtile := common.Metatile{Tiles: make([]uint8, tileCount)}
+		if attributes != nil {
+			mtile.Attributes = make([]common.TileAttributes, tileCount)
+		}
+		for j := range mtile.Tiles {
+			mtile.Tiles[j] = src[getTileOffset(format, count, i, j)]
+			if attributes != nil {
+				mtile.Attributes[j] = common.TileAttributes(attributes[getTileOffset(format, count, i, j)])
+			}
+		}
+		for j, index := range mtile.Tiles {
+			refIndex := format.Addressing.RefIndex(index)
+			bank := mtile.GetAttributes(j).Bank()
+			if _, ok := tileData.Find(bank, refIndex); !ok {
+				absent[common.BankRange{Range: common.IndexRange{Start: index, End: index}, Bank: bank}] = struct{}{}
+			}
+		}
+
+		result.Metatiles = append(result.Metatiles, mtile)
+	}
+
+	absentArr := make([]common.BankRange, 0, len(absent))
+	for i := range absent {
+		absentArr = append(absentArr, i)
+	}
+	sort.Slice(absentArr, func(i, j int) bool {
+		if absentArr[i].Bank != absentArr[j].Bank {
+			return absentArr[i].Bank < absentArr[j].Bank
+		}
+		return absentArr[i].Range.Start < absentArr[j].Range.Start
+	})
+
+	absentRngs := make([]common.BankRange, 0, len(absentArr))
+	for _, rng := range absentArr {
+		if len(absentRngs) != 0 {
+			last := &absentRngs[len(absentRngs)-1]
+			if last.Bank == rng.Bank && last.Range.End == rng.Range.Start-1 {
+				last.Range.End = rng.Range.Start
+				continue
+			}
+		}
+		absentRngs = append(absentRngs, rng)
+	}
+
+	for _, rng := range absentRngs {
+		result.AbsentTiles.Insert(rng)
 	}
 
 	return result
 }
 
-func ExtractTileData(src []byte) *common.Tiles {
-	tileCount := len(src) / common.BytesPerTile
+// ExpandBits replaces color indexes 0 and 1 of decoded 1bpp tiles with the colors
+func ExpandBits(tiles *common.Tiles, colors common.BitColors) {
+	for _, tile := range tiles.Data {
+		for i, pixel := range tile {
+			tile[i] = colors[pixel&1]
+		}
+	}
+}
 
+// PackBits converts tiles which only use the colors to 1bpp color indexes, other colors are an error
+func PackBits(tiles *common.Tiles, colors common.BitColors) (*common.Tiles, error) {
 	result := &common.Tiles{
-		Data: make([][]byte, 0, tileCount),
-		Size: common.MemorySizeFrom(float64(tileCount)*common.BitsPerTile, common.Bytes),
+		Data:    make([][]byte, 0, len(tiles.Data)),
+		Palette: tiles.Palette,
+		Size:    tiles.Size,
 	}
-	for tile := 0; tile < tileCount; tile++ {
-		offset := tile * common.BytesPerTile
-		tileData := getTile(src[offset : offset+common.BytesPerTile])
-		tileCopy := make([]byte, len(tileData))
-		copy(tileCopy, tileData)
-		result.Data = append(result.Data, tileCopy)
+	for i, tile := range tiles.Data {
+		packed := make([]byte, len(tile))
+		for j, pixel := range tile {
+			switch pixel {
+			case colors[1]:
+				packed[j] = 1
+			case colors[0]:
+			default:
+				return nil, fmt.Errorf("tile %d uses color %d, 1bpp tiles may only use colors %d and %d", i, pixel, colors[0], colors[1])
+			}
+		}
+		result.Data = append(result.Data, packed)
 	}
 
-	return result
+	return result, nil
 }
 
-func ExtractMetatileData(src []byte, tileData common.Tree[common.TileRef]) *common.Metatiles {
-	if len(src) < 4 || len(src)%4 != 0 {
-		return nil
+// Encode tiles to binary tile data
+func EncodeTileData(tiles *common.Tiles, format common.TileFormat) []byte {
+	codec := CodecFor(format)
+	result := make([]byte, 0, len(tiles.Data)*codec.BytesPerTile())
+	for _, tile := range tiles.Data {
+		result = append(result, codec.EncodeTile(tile)...)
 	}
 
-	result := common.NewMetatiles()
+	return result
+}
 
-	result.Refs = tileData
+// Encode metatiles to binary data
+// returns: tile indexes and CGB attributes, attributes are nil if no metatile has them
+func EncodeMetatileData(metatiles []common.Metatile, format common.MetatileFormat) ([]byte, []byte) {
+	tileCount := format.Size.TileCount()
+	result := make([]byte, len(metatiles)*tileCount)
+	var attributes []byte
+	for i, mtile := range metatiles {
+		if len(mtile.Attributes) != 0 && attributes == nil {
+			attributes = make([]byte, len(result))
+		}
+		for j := 0; j < len(mtile.Tiles) && j < tileCount; j++ {
+			offset := getTileOffset(format, len(metatiles), i, j)
+			result[offset] = mtile.Tiles[j]
+			if attributes != nil {
+				attributes[offset] = byte(mtile.GetAttributes(j))
+			}
+		}
+	}
+
+	return result, attributes
+}
 
-	absent := map[uint8]struct{}{}
-	for i := 0; i < len(src); i += 4 {
-		tl, tr, bl, br := src[i], src[i+1], src[i+2], src[i+3]
-		arr := []uint8{tl, tr, bl, br}
-		for _, index := range arr {
-			ref := common.TileRef{Range: common.IndexRange{Start: index, End: index}}
-			it := tileData.Find(ref)
-			if it == nil {
-				absent[index] = struct{}{}
+func FlipTile(tile []byte, flip common.TileFlip) []byte {
+	result := make([]byte, len(tile))
+	for y := 0; y < common.TileSizePx; y++ {
+		srcY := y
+		if flip&common.FlipY != 0 {
+			srcY = common.TileSizePx - 1 - y
+		}
+		for x := 0; x < common.TileSizePx; x++ {
+			srcX := x
+			if flip&common.FlipX != 0 {
+				srcX = common.TileSizePx - 1 - x
 			}
+			result[y*common.TileSizePx+x] = tile[srcY*common.TileSizePx+srcX]
 		}
+	}
 
-		result.Metatiles = append(result.Metatiles, common.Metatile{
-			TopLeft:     tl,
-			TopRight:    tr,
-			BottomLeft:  bl,
-			BottomRight: br,
-		})
+	return result
+}
+
+// Remove duplicate tiles
+// tiles: source tile data
+// detectFlips: also treat X, Y and XY-flipped copies of a tile as duplicates
+// returns: compacted tile data and the remapping table indexed by source tile index
+func DeduplicateTiles(tiles *common.Tiles, detectFlips bool) (*common.Tiles, []common.TileRemap) {
+	result := &common.Tiles{
+		Palette: tiles.Palette,
 	}
+	remap := make([]common.TileRemap, 0, len(tiles.Data))
 
-	absentArr := make([]uint8, 0, len(absent))
-	for i := range absent {
-		absentArr = append(absentArr, i)
+	flips := []common.TileFlip{0}
+	if detectFlips {
+		flips = append(flips, common.FlipX, common.FlipY, common.FlipXY)
 	}
-	sort.Slice(absentArr, func(i, j int) bool { return absentArr[i] < absentArr[j] })
 
-	absentRngs := make([]common.IndexRange, 0, len(absentArr))
-	for i := range absentArr {
-		if i == 0 || absentRngs[len(absentRngs)-1].End != absentArr[i]-1 {
-			absentRngs = append(absentRngs, common.IndexRange{Start: absentArr[i], End: absentArr[i]})
-		} else {
-			absentRngs[len(absentRngs)-1].End = absentArr[i]
+	indexes := map[string]int{}
+	for _, tile := range tiles.Data {
+		found := false
+		for _, flip := range flips {
+			flipped := tile
+			if flip != 0 {
+				flipped = FlipTile(tile, flip)
+			}
+			if index, ok := indexes[string(flipped)]; ok {
+				remap = append(remap, common.TileRemap{Index: index, Flip: flip})
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
 		}
+
+		indexes[string(tile)] = len(result.Data)
+		remap = append(remap, common.TileRemap{Index: len(result.Data)})
+		result.Data = append(result.Data, tile)
+		result.Size += common.MemorySizeFrom(float64(len(tile)), common.Bytes)
 	}
 
-	for _, rng := range absentRngs {
-		result.AbsentTiles.Insert(rng)
+	return result, remap
+}
+
+// Rewrite metatile indexes using remapping table returned by DeduplicateTiles
+// addressing: addressing mode of the metatiles, the table is indexed by tile index in the tile data
+// Indexes not covered by the table and tiles from VRAM bank 1 are left as is
+func RemapMetatiles(metatiles []common.Metatile, remap []common.TileRemap, addressing common.AddressingMode) error {
+	for i := range metatiles {
+		mtile := &metatiles[i]
+		for j, index := range mtile.Tiles {
+			index = addressing.RefIndex(index)
+			if int(index) >= len(remap) || mtile.GetAttributes(j).Bank() != 0 {
+				continue
+			}
+			r := remap[index]
+			if r.Index >= common.MaxTilesPerFile {
+				return fmt.Errorf("tile index %d does not fit into metatile data", r.Index)
+			}
+			mtile.Tiles[j] = addressing.RefIndex(uint8(r.Index))
+			if r.Flip == 0 {
+				continue
+			}
+			if len(mtile.Attributes) == 0 {
+				mtile.Attributes = make([]common.TileAttributes, len(mtile.Tiles))
+			}
+			mtile.Attributes[j] ^= common.TileAttributes(r.Flip)
+		}
 	}
 
-	return result
+	return nil
 }
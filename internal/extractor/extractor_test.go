@@ -0,0 +1,257 @@
+package extractor
+
+import (
+	"testing"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTileDataRoundTrip(t *testing.T) {
+	src := []byte{
+		0x7c, 0x7c, 0x00, 0xc6, 0xc6, 0x00, 0x00, 0xfe,
+		0xc6, 0xc6, 0x00, 0xc6, 0xc6, 0x00, 0x00, 0x00,
+		0xff, 0x00, 0x00, 0xff, 0xaa, 0x55, 0x0f, 0xf0,
+		0x01, 0x80, 0x80, 0x01, 0x3c, 0x3c, 0xff, 0xff,
+	}
+
+	tiles := ExtractTileData(src, common.TileFormatGB)
+	assert.Equal(t, 2, len(tiles.Data), "wrong tile count")
+	assert.Equal(t, []byte{0, 3, 3, 3, 3, 3, 0, 0}, tiles.Data[0][:common.TileSizePx])
+	assert.Equal(t, src, EncodeTileData(tiles, common.TileFormatGB))
+
+	// Same tiles with the bit planes stored one after another
+	nes := make([]byte, 0, len(src))
+	for tile := 0; tile < len(src); tile += common.BytesPerTile {
+		for plane := 0; plane < 2; plane++ {
+			for y := 0; y < common.TileSizePx; y++ {
+				nes = append(nes, src[tile+y*2+plane])
+			}
+		}
+	}
+	nesTiles := ExtractTileData(nes, common.TileFormatNES)
+	assert.Equal(t, tiles.Data, nesTiles.Data)
+	assert.Equal(t, nes, EncodeTileData(nesTiles, common.TileFormatNES))
+	assert.Len(t, ExtractTileData(nes[:common.BytesPerTile+1], common.TileFormatNES).Data, 1)
+}
+
+func TestCodecs(t *testing.T) {
+	// Pixel 0 has every bit set, pixel 1 only the highest one
+	tests := []struct {
+		format common.TileFormat
+		size   int
+		set    map[int]byte
+	}{
+		{common.TileFormatSNES4, 32, map[int]byte{0: 0x80, 1: 0x80, 16: 0x80, 17: 0xc0}},
+		{common.TileFormatSNES8, 64, map[int]byte{0: 0x80, 1: 0x80, 16: 0x80, 17: 0x80, 32: 0x80, 33: 0x80, 48: 0x80, 49: 0xc0}},
+		{common.TileFormatGBA4, 32, map[int]byte{0: 0x8f}},
+		{common.TileFormatGBA8, 64, map[int]byte{0: 0xff, 1: 0x80}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format.String(), func(t *testing.T) {
+			codec := CodecFor(test.format)
+			colors := 1 << codec.BitsPerPixel()
+			assert.Equal(t, test.size, codec.BytesPerTile())
+
+			tile := make([]byte, common.BitsPerTile)
+			tile[0], tile[1] = byte(colors-1), byte(colors/2)
+			encoded := make([]byte, test.size)
+			for i, value := range test.set {
+				encoded[i] = value
+			}
+			assert.Equal(t, encoded, codec.EncodeTile(tile))
+			assert.Equal(t, tile, codec.DecodeTile(encoded))
+
+			for i := range tile {
+				tile[i] = byte(i * 7 % colors)
+			}
+			assert.Equal(t, tile, codec.DecodeTile(codec.EncodeTile(tile)))
+			assert.Nil(t, codec.DecodeTile(encoded[1:]))
+		})
+	}
+
+	// PC Engine background tiles are stored like SNES ones
+	assert.Equal(t, CodecFor(common.TileFormatSNES4).BytesPerTile(), CodecFor(common.TileFormatPCE).BytesPerTile())
+}
+
+func TestBitColors(t *testing.T) {
+	src := []byte{0x81, 0x42, 0x24, 0x18, 0x18, 0x24, 0x42, 0x81}
+	tiles := ExtractTileData(src, common.TileFormat1bpp)
+	assert.Len(t, tiles.Data, 1)
+	assert.Equal(t, []byte{1, 0, 0, 0, 0, 0, 0, 1}, tiles.Data[0][:common.TileSizePx])
+
+	colors := common.BitColors{1, 3}
+	ExpandBits(tiles, colors)
+	assert.Equal(t, []byte{3, 1, 1, 1, 1, 1, 1, 3}, tiles.Data[0][:common.TileSizePx])
+
+	packed, err := PackBits(tiles, colors)
+	assert.NoError(t, err)
+	assert.Equal(t, src, EncodeTileData(packed, common.TileFormat1bpp))
+
+	tiles.Data[0][5] = 2
+	_, err = PackBits(tiles, colors)
+	assert.Error(t, err)
+}
+
+func TestDeduplicateTiles(t *testing.T) {
+	tile := make([]byte, common.BitsPerTile)
+	for i := range tile {
+		tile[i] = byte(i % 3)
+	}
+	tile[0] = 3
+	other := make([]byte, common.BitsPerTile)
+
+	tiles := &common.Tiles{Data: [][]byte{
+		tile,
+		other,
+		FlipTile(tile, common.FlipX),
+		FlipTile(tile, common.FlipY),
+		FlipTile(tile, common.FlipXY),
+		tile,
+	}}
+
+	t.Run("exact", func(t *testing.T) {
+		result, remap := DeduplicateTiles(tiles, false)
+		assert.Equal(t, 5, len(result.Data), "wrong tile count")
+		assert.Equal(t, common.TileRemap{Index: 0}, remap[5])
+	})
+
+	t.Run("flips", func(t *testing.T) {
+		result, remap := DeduplicateTiles(tiles, true)
+		assert.Equal(t, 2, len(result.Data), "wrong tile count")
+		assert.Equal(t, []common.TileRemap{
+			{Index: 0},
+			{Index: 1},
+			{Index: 0, Flip: common.FlipX},
+			{Index: 0, Flip: common.FlipY},
+			{Index: 0, Flip: common.FlipXY},
+			{Index: 0},
+		}, remap)
+
+		mtiles := []common.Metatile{
+			{Tiles: []uint8{2, 1, 4, 7}, Attributes: []common.TileAttributes{common.TileAttributes(common.FlipX) | 2, 0, 0, 0}},
+			{Tiles: []uint8{3, 5}},
+		}
+		assert.NoError(t, RemapMetatiles(mtiles, remap, common.Addressing8000))
+		assert.Equal(t, []common.Metatile{
+			{Tiles: []uint8{0, 1, 0, 7}, Attributes: []common.TileAttributes{2, 0, common.TileAttributes(common.FlipXY), 0}},
+			{Tiles: []uint8{0, 0}, Attributes: []common.TileAttributes{common.TileAttributes(common.FlipY), 0}},
+		}, mtiles)
+
+		// In 8800 mode the table is indexed by position in the tile data, tiles from bank 1 are kept
+		mtiles = []common.Metatile{{Tiles: []uint8{0x82, 0x84, 0x02}, Attributes: []common.TileAttributes{0, common.AttrBank, 0}}}
+		assert.NoError(t, RemapMetatiles(mtiles, remap, common.Addressing8800))
+		assert.Equal(t, []common.Metatile{
+			{Tiles: []uint8{0x80, 0x84, 0x02}, Attributes: []common.TileAttributes{common.TileAttributes(common.FlipX), common.AttrBank, 0}},
+		}, mtiles)
+	})
+}
+
+func TestMetatileLayouts(t *testing.T) {
+	size := common.MetatileSize{Width: 2, Height: 2}
+	metatiles := []common.Metatile{
+		{Tiles: []uint8{0, 1, 2, 3}, Attributes: []common.TileAttributes{0x10, 0x11, 0x12, 0x13}},
+		{Tiles: []uint8{4, 5, 6, 7}, Attributes: []common.TileAttributes{0x14, 0x15, 0x16, 0x17}},
+	}
+	refs := common.NewTileRefs()
+
+	tests := []struct {
+		name    string
+		layout  common.MetatileLayout
+		encoded []byte
+	}{
+		{"row major", common.LayoutRowMajor, []byte{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"column major", common.LayoutColumnMajor, []byte{0, 2, 1, 3, 4, 6, 5, 7}},
+		{"planar", common.LayoutPlanar, []byte{0, 4, 1, 5, 2, 6, 3, 7}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format := common.MetatileFormat{Size: size, Layout: test.layout}
+			attributes := make([]byte, len(test.encoded))
+			for i := range attributes {
+				attributes[i] = test.encoded[i] | 0x10
+			}
+
+			encoded, encodedAttributes := EncodeMetatileData(metatiles, format)
+			assert.Equal(t, test.encoded, encoded)
+			assert.Equal(t, attributes, encodedAttributes)
+			assert.Equal(t, metatiles, ExtractMetatileData(encoded, encodedAttributes, refs, format).Metatiles)
+		})
+	}
+}
+
+func TestAddressing8800(t *testing.T) {
+	refs := common.NewTileRefs()
+	assert.NoError(t, refs.Insert(common.TileRef{File: "block1.chr", Range: common.IndexRange{Start: 0, End: 0x7f}}))
+	format := common.MetatileFormat{
+		Size:       common.MetatileSize{Width: 2, Height: 1},
+		Addressing: common.Addressing8800,
+	}
+
+	mtiles := ExtractMetatileData([]byte{0x80, 0xff, 0x00, 0x7f}, nil, refs, format)
+	assert.Equal(t, common.Addressing8800, mtiles.Addressing)
+	assert.Equal(t, 2, mtiles.AbsentTiles.Size(), "wrong absent range count")
+	assert.True(t, mtiles.AbsentTiles.Contains(common.BankRange{Range: common.IndexRange{Start: 0, End: 0}}))
+	assert.True(t, mtiles.AbsentTiles.Contains(common.BankRange{Range: common.IndexRange{Start: 0x7f, End: 0x7f}}))
+}
+
+func TestAbsentTilesBank(t *testing.T) {
+	refs := common.NewTileRefs()
+	assert.NoError(t, refs.Insert(common.TileRef{File: "bank0.chr", Range: common.IndexRange{Start: 0, End: 1}}))
+	format := common.MetatileFormat{Size: common.MetatileSize{Width: 2, Height: 1}}
+
+	// Tiles 0 and 1 of bank 1 and tile 2 of bank 0 don't have a ref
+	mtiles := ExtractMetatileData([]byte{0, 1, 1, 2}, []byte{0x08, 0x08, 0, 0}, refs, format)
+	assert.Equal(t, 2, mtiles.AbsentTiles.Size(), "wrong absent range count")
+	assert.True(t, mtiles.AbsentTiles.Contains(common.BankRange{Range: common.IndexRange{Start: 2, End: 2}}))
+	assert.True(t, mtiles.AbsentTiles.Contains(common.BankRange{Range: common.IndexRange{Start: 0, End: 1}, Bank: 1}))
+}
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		compression common.Compression
+		data        []byte
+		compressed  []byte
+		// Length of the compressed data cut in the middle of an item
+		truncated int
+	}{
+		{common.CompressionRLE,
+			[]byte{0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0x22, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa},
+			[]byte{0xfe, 0xaa, 0x02, 0x80, 0x00, 0x2a, 0xfd, 0xaa, 0x03, 0x80, 0x00, 0x2a, 0x22, 0xf7, 0xaa}, 5},
+		{common.CompressionPB16,
+			[]byte{0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0},
+			[]byte{0xcc, 0xff, 0xff, 0, 0}, 4},
+		{common.CompressionLZSS,
+			make([]byte, 8),
+			[]byte{0x10, 8, 0, 0, 0x20, 0, 0, 0x30, 0x01, 0, 0, 0}, 8},
+	}
+
+	// Tiles with runs, repeated tiles and noise
+	tiles := make([]byte, 0, 64*common.BytesPerTile)
+	for i := 0; i < cap(tiles); i++ {
+		tiles = append(tiles, byte(i/40*37+i*i%3))
+	}
+
+	for _, test := range tests {
+		t.Run(test.compression.String(), func(t *testing.T) {
+			compressor := CompressorFor(test.compression)
+			compressed, err := compressor.Compress(test.data)
+			assert.NoError(t, err)
+			assert.Equal(t, test.compressed, compressed)
+
+			for _, data := range [][]byte{test.data, tiles, {}} {
+				compressed, err := compressor.Compress(data)
+				assert.NoError(t, err)
+				decompressed, err := compressor.Decompress(compressed)
+				assert.NoError(t, err)
+				assert.Equal(t, len(data), len(decompressed))
+				assert.Equal(t, data, append([]byte{}, decompressed...))
+			}
+
+			_, err = compressor.Decompress(test.compressed[:test.truncated])
+			assert.Error(t, err)
+		})
+	}
+}
@@ -3,65 +3,201 @@ package file_manager
 import (
 	"container/list"
 	"errors"
+	"sync"
 
 	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
 )
 
+// CacheStats describes tile cache usage since the manager was created
+type CacheStats struct {
+	// Tile lookups in loaded or loading files
+	Hits uint64
+	// Tile lookups which loaded a file
+	Misses uint64
+	// Files dropped to fit the cache size
+	Evictions uint64
+	// Files dropped because they changed since they were loaded
+	Stale   uint64
+	Size    common.MemorySize
+	MaxSize common.MemorySize
+}
+
+// cacheEntry is added to the cache before the file is loaded, so that concurrent requests for the same file
+// wait for a single load. ready is closed once tiles or err are set
+type cacheEntry struct {
+	ready chan struct{}
+	tiles *common.Tiles
+	err   error
+	stamp common.FileStamp
+}
+
+// A file is cached separately for each tile format and compression it is decoded with
+type tileKey struct {
+	file        string
+	format      common.TileFormat
+	compression common.Compression
+}
+
+// tileCache is an LRU cache of tile data, safe for concurrent use
 type tileCache struct {
-	cache    map[string]common.Tiles
+	mutex   sync.Mutex
+	entries map[tileKey]*cacheEntry
+	// Loaded files, least recently used first
 	queue    *list.List
-	queueMap map[string]*list.Element
+	queueMap map[tileKey]*list.Element
 	maxSize  common.MemorySize
 	size     common.MemorySize
+	stats    CacheStats
+	load     func(key tileKey) (*common.Tiles, error)
 }
 
-func newTileCache(size common.MemorySize) tileCache {
-	return tileCache{
-		cache:    map[string]common.Tiles{},
+// opts.Format and opts.Compression are replaced with the ones of the requested key
+func newTileCache(size common.MemorySize, opts tileset.TileOptions) *tileCache {
+	return &tileCache{
+		entries:  map[tileKey]*cacheEntry{},
 		queue:    list.New(),
-		queueMap: map[string]*list.Element{},
+		queueMap: map[tileKey]*list.Element{},
 		maxSize:  size,
+		load: func(key tileKey) (*common.Tiles, error) {
+			opts := opts
+			opts.Format = key.format
+			opts.Compression = key.compression
+			return tileset.LoadTiles(common.HostFS, key.file, opts)
+		},
 	}
 }
 
-func (c *tileCache) getTile(file string, index uint8) ([]byte, error) {
-	data, ok := c.cache[file]
-	if !ok {
-		tiles, err := ExtractTileData(file)
-		if err != nil {
-			return nil, common.Wrap(err, "cache", "could not get tile data")
-		}
+func (c *tileCache) getTile(key tileKey, index uint8) ([]byte, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		c.stats.Hits++
+		c.mutex.Unlock()
+		<-entry.ready
+	} else {
+		c.stats.Misses++
+		entry = &cacheEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+		c.mutex.Unlock()
 
-		for c.size != 0 && tiles.Size+c.size > c.maxSize {
-			front := c.queue.Front()
-			name := front.Value.(string)
+		// The file is checked before loading, so that changes made during loading are detected by refresh
+		entry.stamp = common.NewFileStamp(key.file)
+		entry.tiles, entry.err = c.load(key)
 
-			if size := c.cache[name].Size; size > c.size {
-				c.size = common.MemorySizeFrom(0, common.Bytes)
+		c.mutex.Lock()
+		// Entries removed while loading are returned to the waiting requests, but not cached
+		if c.entries[key] == entry {
+			if entry.err != nil {
+				// Failed loads are not cached, waiting requests get the same error
+				delete(c.entries, key)
 			} else {
-				c.size -= size
+				c.evict(entry.tiles.Size)
+				c.queueMap[key] = c.queue.PushBack(key)
+				c.size += entry.tiles.Size
 			}
-			c.queue.Remove(front)
-			delete(c.queueMap, name)
-			delete(c.cache, name)
 		}
+		close(entry.ready)
+		c.mutex.Unlock()
+	}
 
-		c.queueMap[file] = c.queue.PushBack(file)
+	if entry.err != nil {
+		return nil, common.Wrap(entry.err, "cache", "could not get tile data")
+	}
+	if int(index) >= len(entry.tiles.Data) {
+		return nil, errors.New("tile index out of bounds")
+	}
 
-		c.cache[file] = *tiles
-		data = c.cache[file]
-		c.size += tiles.Size
+	c.mutex.Lock()
+	// The entry might have been evicted by another request
+	if elem, ok := c.queueMap[key]; ok {
+		c.queue.MoveToBack(elem)
 	}
+	c.mutex.Unlock()
 
-	if int(index) >= len(data.Data) {
-		return nil, errors.New("tile index out of bounds")
+	return entry.tiles.Data[index], nil
+}
+
+// refresh drops the file if its modification time or size changed since it was loaded.
+// Files aren't checked on every lookup, since a file is usually looked up once per tile
+func (c *tileCache) refresh(file string) {
+	if file == common.StdStream {
+		return
+	}
+
+	c.mutex.Lock()
+	keys := c.keys(file)
+	entries := make([]*cacheEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, c.entries[key])
+	}
+	c.mutex.Unlock()
+
+	stamp := common.NewFileStamp(file)
+	for i, entry := range entries {
+		<-entry.ready
+		if entry.err != nil || stamp == entry.stamp {
+			continue
+		}
+
+		c.mutex.Lock()
+		if c.entries[keys[i]] == entry {
+			c.drop(keys[i])
+			c.stats.Stale++
+		}
+		c.mutex.Unlock()
 	}
+}
 
-	c.queue.MoveToBack(c.queueMap[file])
+// remove drops the file from the cache, so that it is loaded again by the next request
+func (c *tileCache) remove(file string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	return data.Data[index], nil
+	for _, key := range c.keys(file) {
+		c.drop(key)
+		c.stats.Stale++
+	}
+}
+
+// keys returns keys of every format the file is cached with, must be called with the mutex locked
+func (c *tileCache) keys(file string) []tileKey {
+	var result []tileKey
+	for key := range c.entries {
+		if key.file == file {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// evict removes least recently used files until there is enough space for size bytes,
+// must be called with the mutex locked
+func (c *tileCache) evict(size common.MemorySize) {
+	for c.size != 0 && size+c.size > c.maxSize {
+		c.drop(c.queue.Front().Value.(tileKey))
+		c.stats.Evictions++
+	}
+}
+
+// drop must be called with the mutex locked
+func (c *tileCache) drop(key tileKey) {
+	if elem, ok := c.queueMap[key]; ok {
+		if size := c.entries[key].tiles.Size; size > c.size {
+			c.size = common.MemorySizeFrom(0, common.Bytes)
+		} else {
+			c.size -= size
+		}
+		c.queue.Remove(elem)
+		delete(c.queueMap, key)
+	}
+	delete(c.entries, key)
 }
 
-func (c tileCache) getSize() common.MemorySize {
-	return c.size
+func (c *tileCache) getStats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.stats
+	stats.Size, stats.MaxSize = c.size, c.maxSize
+	return stats
 }
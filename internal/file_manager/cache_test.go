@@ -0,0 +1,110 @@
+package file_manager
+
+import (
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCache(size common.MemorySize, loads *int32) *tileCache {
+	cache := newTileCache(size, tileset.TileOptions{Strict: true})
+	cache.load = func(key tileKey) (*common.Tiles, error) {
+		atomic.AddInt32(loads, 1)
+		return &common.Tiles{
+			Data: [][]byte{[]byte(key.file)},
+			Size: common.MemorySizeFrom(1, common.Kilobytes),
+		}, nil
+	}
+	return cache
+}
+
+func TestCacheSingleFlight(t *testing.T) {
+	const callers = 16
+	var loads int32
+	cache := newTestCache(common.MemorySizeFrom(10, common.Kilobytes), &loads)
+	// The load is blocked until the other callers found the entry and wait for it to be ready
+	release := make(chan struct{})
+	load := cache.load
+	cache.load = func(key tileKey) (*common.Tiles, error) {
+		<-release
+		return load(key)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tile, err := cache.getTile(tileKey{file: "a.chr"}, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("a.chr"), tile)
+		}()
+	}
+	assert.Eventually(t, func() bool { return cache.getStats().Hits == callers-1 }, 5*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), loads)
+	assert.Equal(t, common.MemorySizeFrom(1, common.Kilobytes), cache.getStats().Size)
+}
+
+func TestCacheEviction(t *testing.T) {
+	var loads int32
+	cache := newTestCache(common.MemorySizeFrom(2, common.Kilobytes), &loads)
+
+	for _, file := range []string{"a", "b", "a", "c", "a", "b"} {
+		_, err := cache.getTile(tileKey{file: file}, 0)
+		assert.NoError(t, err)
+	}
+	_, err := cache.getTile(tileKey{file: "a"}, 1)
+	assert.Error(t, err)
+
+	// b is evicted by c, then c by b
+	assert.Equal(t, int32(4), loads)
+	stats := cache.getStats()
+	assert.Equal(t, CacheStats{
+		Hits:      3,
+		Misses:    4,
+		Evictions: 2,
+		Size:      common.MemorySizeFrom(2, common.Kilobytes),
+		MaxSize:   common.MemorySizeFrom(2, common.Kilobytes),
+	}, stats)
+}
+
+func TestCacheStale(t *testing.T) {
+	file := path.Join(t.TempDir(), "a.chr")
+	assert.NoError(t, os.WriteFile(file, make([]byte, common.BytesPerTile), 0666))
+	cache := newTileCache(common.MemorySizeFrom(10, common.Kilobytes), tileset.TileOptions{Strict: true})
+
+	_, err := cache.getTile(tileKey{file: file}, 0)
+	assert.NoError(t, err)
+	_, err = cache.getTile(tileKey{file: file}, 1)
+	assert.Error(t, err)
+
+	cache.refresh(file)
+	assert.Equal(t, uint64(0), cache.getStats().Stale)
+
+	assert.NoError(t, os.WriteFile(file, make([]byte, common.BytesPerTile*2), 0666))
+	cache.refresh(file)
+	_, err = cache.getTile(tileKey{file: file}, 1)
+	assert.NoError(t, err)
+
+	stats := cache.getStats()
+	assert.Equal(t, uint64(1), stats.Stale)
+	assert.Equal(t, uint64(2), stats.Misses)
+
+	// Every format of a file is dropped
+	_, err = cache.getTile(tileKey{file: file, format: common.TileFormatNES}, 1)
+	assert.NoError(t, err)
+	cache.remove(file)
+	stats = cache.getStats()
+	assert.Equal(t, uint64(3), stats.Stale)
+	assert.Equal(t, uint64(0), stats.Size.Bytes())
+}
@@ -1,206 +1,447 @@
 package file_manager
 
 import (
-	"errors"
+	"bytes"
+	"fmt"
 	"image"
-	"image/color"
 	"image/png"
+	"io"
 	"io/fs"
-	"os"
 	"path"
+	"path/filepath"
 
 	"github.com/Onlymiind/tileset_manager/internal/common"
 	"github.com/Onlymiind/tileset_manager/internal/extractor"
 	"github.com/Onlymiind/tileset_manager/internal/serializer"
-	"github.com/valyala/fastjson"
+	"github.com/Onlymiind/tileset_manager/pkg/tileset"
 )
 
+// IsTileData accepts compressed tile data files as well, e.g. tiles.chr.pb16
 func IsTileData(info fs.FileInfo) bool {
-	return path.Ext(info.Name()) == common.ExtensionTileData && info.Mode().IsRegular()
+	return path.Ext(common.TrimCompression(info.Name())) == common.ExtensionTileData && info.Mode().IsRegular()
 }
 
 func IsMetatileData(info fs.FileInfo) bool {
 	return path.Ext(info.Name()) == common.ExtensionMetatileData && info.Mode().IsRegular()
 }
 
-func TileDataToImage(tileData *common.Tiles) *image.Paletted {
-	width := common.OutTilesPerRow
-	if len(tileData.Data) < width {
-		width = len(tileData.Data)
-	}
+// Manager writes output files and provides tiles for rendering from a cache, see tileset.TileSource.
+// Manager is safe for concurrent use
+type Manager struct {
+	cache *tileCache
+	out   common.Output
+	// tiles.Format is the format of written tile data and of tile data files which don't specify one,
+	// see common.TileFormatOf. tiles.Compression is the compression of written tile data and of tile data files
+	// which don't specify one, see common.CompressionOf
+	tiles tileset.TileOptions
+	// Receives sizes of compressed tile data, may be nil
+	log io.Writer
+	// Paths of written files, only set for managers returned by Tracked
+	written *[]string
+}
 
-	height := len(tileData.Data) / width
-	if len(tileData.Data)%width != 0 {
-		height++
+func NewManager(cfg *common.Config) *Manager {
+	tiles := tileset.TileOptions{
+		Format:      cfg.TileFormat,
+		Compression: cfg.Compression,
+		BitColors:   cfg.BitColors,
+		Palette:     cfg.Palette,
+		Strict:      cfg.Strict,
 	}
-
-	img := image.NewPaletted(image.Rect(0, 0, width*common.TileSizePx, height*common.TileSizePx),
-		[]color.Color(tileData.Palette))
-	x, y := 0, 0
-	for _, tile := range tileData.Data {
-		writeTileToImage(img, tileData.Palette, tile, x, y)
-		x += common.TileSizePx
-		if x >= width*common.TileSizePx {
-			x %= width * common.TileSizePx
-			y += common.TileSizePx
-		}
+	return &Manager{
+		cache: newTileCache(cfg.CacheSize, tiles),
+		out:   cfg.Output,
+		tiles: tiles,
 	}
+}
 
-	return img
+// Tracked returns a manager sharing the cache with m, which records paths of written files.
+// Unlike m, it must not be used concurrently
+func (m *Manager) Tracked() *Manager {
+	result := *m
+	result.written = &[]string{}
+	return &result
 }
 
-func ExtractTileData(filePath string) (*common.Tiles, error) {
-	switch path.Ext(filePath) {
-	case common.ExtensionJSON:
-		return serializer.ParseTileData(filePath)
-	case common.ExtensionPNG:
-		//TODO
-		return nil, errors.New("not implemented")
-	default:
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return nil, err
-		}
+// WithTileFormat returns a manager sharing the cache and written files with m, which uses the tile format
+func (m *Manager) WithTileFormat(format common.TileFormat) *Manager {
+	result := *m
+	result.tiles.Format = format
+	return &result
+}
 
-		tileData := extractor.ExtractTileData(data)
+func (m *Manager) TileFormat() common.TileFormat {
+	return m.tiles.Format
+}
 
-		return tileData, nil
-	}
+// WithCompression returns a manager sharing the cache and written files with m, which uses the compression
+func (m *Manager) WithCompression(compression common.Compression) *Manager {
+	result := *m
+	result.tiles.Compression = compression
+	return &result
 }
 
-func ExtractMetatileData(filePath string, tileData common.Tree[common.TileRef]) (*common.Metatiles, error) {
+func (m *Manager) Compression() common.Compression {
+	return m.tiles.Compression
+}
 
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
+// TileDataExtension is the extension of files written by WriteTileData, e.g. .nes.chr.pb16
+func (m *Manager) TileDataExtension() string {
+	return m.tiles.Format.Extension() + m.tiles.Compression.Extension()
+}
+
+// WithLog returns a manager sharing the cache and written files with m, which reports sizes of compressed
+// tile data to log
+func (m *Manager) WithLog(log io.Writer) *Manager {
+	result := *m
+	result.log = log
+	return &result
+}
+
+// ReportCompression writes raw and compressed sizes of the tile data file to the log
+func (m *Manager) ReportCompression(file string, compression common.Compression, raw, compressed int) {
+	if m.log == nil || compression == common.CompressionNone {
+		return
 	}
+	ratio := 0.0
+	if raw != 0 {
+		ratio = float64(compressed) / float64(raw) * 100
+	}
+	fmt.Fprintf(m.log, "%s: %d bytes, %d bytes compressed with %s (%.1f%%)\n", file, raw, compressed, compression, ratio)
+}
 
-	tileset := extractor.ExtractMetatileData(data, tileData)
+// TileOptions returns the options used to load tile data files in the tile format of m
+func (m *Manager) TileOptions() tileset.TileOptions {
+	return m.tiles
+}
 
-	return tileset, nil
+// Written returns paths of files written by a manager returned by Tracked
+func (m *Manager) Written() []string {
+	if m.written == nil {
+		return nil
+	}
+	return *m.written
 }
 
-type Manager struct {
-	cache tileCache
-	out   common.Output
+func (m *Manager) writeFile(path string, data []byte) error {
+	err := common.WriteFile(path, data)
+	if err == nil && m.written != nil {
+		*m.written = append(*m.written, path)
+	}
+	return err
 }
 
-func NewManager(cfg *common.Config) *Manager {
-	return &Manager{
-		cache: newTileCache(cfg.CacheSize),
-		out:   cfg.Output,
+// Invalidate drops changed files from the tile cache
+func (m *Manager) Invalidate(files ...string) {
+	for _, file := range files {
+		m.cache.remove(file)
 	}
 }
 
-func (m *Manager) CacheSize() common.MemorySize {
-	return m.cache.getSize()
+func (m *Manager) OutputType() common.OutputType {
+	return m.out.Type
+}
+
+func (m *Manager) CacheStats() CacheStats {
+	return m.cache.getStats()
+}
+
+// Tile implements tileset.TileSource
+func (m *Manager) Tile(file string, index uint8) ([]byte, error) {
+	return m.cache.getTile(tileKey{
+		file:        file,
+		format:      common.TileFormatOf(file, m.tiles.Format),
+		compression: common.CompressionOf(file, m.tiles.Compression),
+	}, index)
+}
+
+// refreshCache reloads tile data files changed since they were cached
+func (m *Manager) refreshCache(refs common.TileRefs) {
+	for node := refs.Begin(); node != nil; node = node.Next() {
+		m.cache.refresh(node.GetValue().File)
+	}
 }
 
 func (m *Manager) WritePNG(img *image.Paletted, name string, isTileData bool) error {
-	imgFile, err := os.OpenFile(m.getOutPath(name, common.ExtensionPNG, isTileData), os.O_WRONLY|os.O_CREATE, 0777)
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
 	if err != nil {
-		return common.Wrap(err, "failed to open file")
+		return common.Wrap(err, "failed to encode image")
 	}
-	err = png.Encode(imgFile, img)
+	err = m.writeFile(m.getOutPath(name, common.ExtensionPNG, isTileData), buf.Bytes())
 	if err != nil {
-		return common.Wrap(err, "failed to encode image")
+		return common.Wrap(err, "failed to write image")
 	}
-	return imgFile.Close()
+	return nil
+}
+
+func (m *Manager) WriteTileJSON(tiles *common.Tiles, name string) error {
+	return m.writeJSON(name+".tile", true, func(w io.Writer) error {
+		return tileset.WriteTileJSON(w, tiles)
+	})
+}
+
+func (m *Manager) WriteMetatileJSON(mtiles *common.Metatiles, name string) error {
+	return m.writeJSON(name+".mtile", false, func(w io.Writer) error {
+		return tileset.WriteMetatileJSON(w, mtiles)
+	})
 }
 
-func (m *Manager) WriteJSON(json *fastjson.Value, name string, isTileData bool) error {
-	err := os.WriteFile(m.getOutPath(name, common.ExtensionJSON, isTileData), json.MarshalTo(nil), 0666)
+// WriteMapJSON writes a .map.json file with inline cells
+func (m *Manager) WriteMapJSON(tileMap *common.TileMap, name string) error {
+	return m.writeJSON(name+".map", false, func(w io.Writer) error {
+		return tileset.WriteMapJSON(w, tileMap)
+	})
+}
+
+// MetatileJSONPath is the path of the file written by WriteMetatileJSON
+func (m *Manager) MetatileJSONPath(name string) string {
+	return m.getOutPath(name+".mtile", common.ExtensionJSON, false)
+}
+
+func (m *Manager) writeJSON(name string, isTileData bool, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	err := write(&buf)
+	if err == nil {
+		err = m.writeFile(m.getOutPath(name, common.ExtensionJSON, isTileData), buf.Bytes())
+	}
 	if err != nil {
 		return common.Wrap(err, "failed to write json")
 	}
 	return nil
 }
 
-func (m *Manager) MetatileToImage(tileset *common.Metatiles) *image.Paletted {
-	width := common.OutTilesPerRow
-	if len(tileset.Metatiles) < width {
-		width = len(tileset.Metatiles)
+// WriteTilesTSX writes a Tiled tileset of the image written by WritePNG, tileFile is the tile data of the tileset
+func (m *Manager) WriteTilesTSX(tiles *common.Tiles, name, tileFile string) error {
+	tsxPath := m.getOutPath(name, common.ExtensionTSX, true)
+	return m.writeTSX(tsxPath, func(w io.Writer) error {
+		return tileset.WriteTilesTSX(w, tiles, name, name+common.ExtensionPNG, relativePath(tsxPath, tileFile))
+	})
+}
+
+// WriteMetatileTSX writes a Tiled tileset of the image written by WritePNG, metatileFile is the .mtile.json file of the tileset
+func (m *Manager) WriteMetatileTSX(mtiles *common.Metatiles, name, metatileFile string) error {
+	tsxPath := m.getOutPath(name, common.ExtensionTSX, false)
+	return m.writeTSX(tsxPath, func(w io.Writer) error {
+		return tileset.WriteMetatileTSX(w, mtiles, name, name+common.ExtensionPNG, relativePath(tsxPath, metatileFile))
+	})
+}
+
+func (m *Manager) writeTSX(tsxPath string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	err := write(&buf)
+	if err == nil {
+		err = m.writeFile(tsxPath, buf.Bytes())
+	}
+	if err != nil {
+		return common.Wrap(err, "failed to write tileset")
 	}
+	return nil
+}
 
-	height := len(tileset.Metatiles) / width
-	if len(tileset.Metatiles)%width != 0 {
-		height++
+// relativePath returns target relative to the directory of file, paths of the files read by Tiled are relative to the referencing file
+func relativePath(file, target string) string {
+	if file == common.StdStream {
+		return target
+	}
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return target
 	}
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return target
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
 
-	actualPalette := make(outPalette, len(tileset.Palette))
-	copy(actualPalette, tileset.Palette)
-	if tileset.AbsentTiles.Size() != 0 {
-		actualPalette = addTransparent(tileset.Palette)
+func (m *Manager) WriteBinary(data []byte, name, extension string, isTileData bool) error {
+	err := m.writeFile(m.GetBinaryPath(name, extension, isTileData), data)
+	if err != nil {
+		return common.Wrap(err, "failed to write binary data")
 	}
+	return nil
+}
 
-	img := image.NewPaletted(image.Rect(0, 0, width*common.MetatileSizePx, height*common.MetatileSizePx),
-		[]color.Color(actualPalette))
+// EncodedTiles is tile data converted to the tile format and compressed by WriteTileData,
+// ExportTileData reuses it instead of encoding the tiles again
+type EncodedTiles struct {
+	data []byte
+	// Size of the uncompressed data
+	raw int
+}
 
-	x, y := 0, 0
+// WriteTileData writes binary tile data, the extension names the tile format and the compression, see TileDataExtension
+func (m *Manager) WriteTileData(tiles *common.Tiles, name string) (*EncodedTiles, error) {
+	encoded, err := m.encodeTiles(tiles)
+	if err != nil {
+		return nil, err
+	}
+	m.ReportCompression(m.GetBinaryPath(name, m.TileDataExtension(), true), m.tiles.Compression, encoded.raw, len(encoded.data))
+	return encoded, m.WriteBinary(encoded.data, name, m.TileDataExtension(), true)
+}
 
-	for _, mtile := range tileset.Metatiles {
-		m.writeMetatileTile(tileset, img, mtile.TopLeft, actualPalette, x, y)
-		m.writeMetatileTile(tileset, img, mtile.TopRight, actualPalette, x+common.TileSizePx, y)
-		m.writeMetatileTile(tileset, img, mtile.BottomLeft, actualPalette, x, y+common.TileSizePx)
-		m.writeMetatileTile(tileset, img, mtile.BottomRight, actualPalette, x+common.TileSizePx, y+common.TileSizePx)
-		x += common.MetatileSizePx
-		if x >= width*common.MetatileSizePx {
-			x %= width * common.MetatileSizePx
-			y += common.MetatileSizePx
+// encodeTiles converts tiles to the tile format and compresses them, 1bpp tiles may only use the bit colors
+func (m *Manager) encodeTiles(tiles *common.Tiles) (*EncodedTiles, error) {
+	var err error
+	if m.tiles.Format == common.TileFormat1bpp {
+		tiles, err = tileset.PackBits(tiles, m.tiles.BitColors)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return img
+	var buf bytes.Buffer
+	if err := tileset.EncodeTiles(&buf, tiles, m.tiles.Format); err != nil {
+		return nil, err
+	}
+	data, err := tileset.Compress(buf.Bytes(), m.tiles.Compression)
+	if err != nil {
+		return nil, common.Wrap(err, "failed to compress tile data")
+	}
+	return &EncodedTiles{data: data, raw: buf.Len()}, nil
 }
 
-func (m *Manager) writeMetatileTile(tileset *common.Metatiles, img *image.Paletted, index uint8, palette outPalette, x, y int) {
-	refIt := tileset.Refs.Find(common.TileRef{Range: common.IndexRange{Start: index, End: index}})
-	if refIt == nil {
-		return
+// WriteMetatileData writes binary metatile data, attribute data is only written if the metatiles have attributes
+func (m *Manager) WriteMetatileData(mtiles *common.Metatiles, format common.MetatileFormat, name string) error {
+	data, attributes, err := encodeMetatiles(mtiles, format)
+	if err != nil {
+		return err
 	}
-	ref := refIt.GetValue()
-	if len(ref.File) == 0 {
-		return
+	err = m.WriteBinary(data, name, common.ExtensionMetatileData, false)
+	if err == nil && attributes != nil {
+		err = m.WriteBinary(attributes, name, common.ExtensionAttributes, false)
 	}
+	return err
+}
 
-	tile, err := m.cache.getTile(ref.File, ref.Offset+(index-ref.Range.Start))
-	if err != nil {
-		return
+// encodeMetatiles returns tile indexes and attributes of the metatiles, attributes are nil if no metatile has them
+func encodeMetatiles(mtiles *common.Metatiles, format common.MetatileFormat) ([]byte, []byte, error) {
+	var data, attributes bytes.Buffer
+	if err := tileset.EncodeMetatiles(&data, &attributes, mtiles.Metatiles, format); err != nil {
+		return nil, nil, err
+	}
+	if attributes.Len() == 0 {
+		return data.Bytes(), nil, nil
 	}
+	return data.Bytes(), attributes.Bytes(), nil
+}
 
-	writeTileToImage(img, palette, tile, x, y)
+// Write tile data as assembly, C or raw 2bpp depending on output type, the data is compressed with the compression of m.
+// encoded is the result of WriteTileData of m, the tiles are encoded if it is nil
+func (m *Manager) ExportTileData(tiles *common.Tiles, encoded *EncodedTiles, name string) error {
+	if !m.out.Type.Has(common.OutputASM | common.OutputC | common.OutputRaw) {
+		return nil
+	}
+	var err error
+	if encoded == nil {
+		encoded, err = m.encodeTiles(tiles)
+		if err != nil {
+			return err
+		}
+	}
+	err = m.exportSource(name, name+".tile", true, serializer.SourceArray{
+		Suffix:    "tiles",
+		Data:      encoded.data,
+		CountName: "TILE_COUNT",
+		Count:     len(tiles.Data),
+	})
+	if err != nil {
+		return err
+	}
 
+	if m.out.Type.Has(common.OutputRaw) {
+		// Raw tile data is named by its color depth, e.g. .2bpp or .2bpp.pb16
+		extension := fmt.Sprintf(".%dbpp", extractor.CodecFor(m.tiles.Format).BitsPerPixel()) + m.tiles.Compression.Extension()
+		m.ReportCompression(m.GetBinaryPath(name, extension, true), m.tiles.Compression, encoded.raw, len(encoded.data))
+		return m.WriteBinary(encoded.data, name, extension, true)
+	}
+	return nil
 }
 
-func (m *Manager) getOutPath(name, extension string, isTileData bool) string {
-	isJSON := extension == common.ExtensionJSON
-	return path.Join(m.out.GetOutputPath(isTileData, isJSON), name+extension)
+// Write metatile data as assembly, C or raw binary depending on output type
+func (m *Manager) ExportMetatileData(mtiles *common.Metatiles, format common.MetatileFormat, name string) error {
+	data, attributes, err := encodeMetatiles(mtiles, format)
+	if err != nil {
+		return err
+	}
+	arrays := []serializer.SourceArray{{
+		Suffix:     "metatiles",
+		Data:       data,
+		LineLength: format.Size.TileCount(),
+		CountName:  "METATILE_COUNT",
+		Count:      len(mtiles.Metatiles),
+	}}
+	if attributes != nil {
+		arrays = append(arrays, serializer.SourceArray{
+			Suffix:     "attributes",
+			Data:       attributes,
+			LineLength: format.Size.TileCount(),
+		})
+	}
+
+	err = m.exportSource(name, name+".mtile", false, arrays...)
+	if err != nil {
+		return err
+	}
+
+	if m.out.Type.Has(common.OutputRaw) {
+		return m.WriteMetatileData(mtiles, format, name)
+	}
+	return nil
 }
 
-type outPalette []color.Color
+func (m *Manager) exportSource(name, fileName string, isTileData bool, arrays ...serializer.SourceArray) error {
+	if m.out.Type.Has(common.OutputASM) {
+		err := m.WriteBinary(serializer.ToASM(name, "ROMX", arrays...), fileName, common.ExtensionASM, isTileData)
+		if err != nil {
+			return err
+		}
+	}
+	if m.out.Type.Has(common.OutputC) {
+		header, source := serializer.ToC(fileName, name, arrays...)
+		err := m.WriteBinary(header, fileName, common.ExtensionCHeader, isTileData)
+		if err != nil {
+			return err
+		}
+		err = m.WriteBinary(source, fileName, common.ExtensionCSource, isTileData)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-func addTransparent(palette []color.Color) outPalette {
-	actualPalette := make(outPalette, 0, len(palette)+1)
-	actualPalette = append(actualPalette, color.Transparent)
-	actualPalette = append(actualPalette, palette...)
-	return actualPalette
+// MetatileToImage renders metatiles, tile data files changed since they were cached are reloaded
+func (m *Manager) MetatileToImage(mtiles *common.Metatiles) (*image.Paletted, error) {
+	m.refreshCache(mtiles.Refs)
+	return tileset.RenderMetatiles(m, mtiles)
 }
 
-func (p outPalette) getColorIndex(rawIndex uint8) uint8 {
-	if p[0] != color.Transparent {
-		return rawIndex
+// MapToImage renders a map, tile data files changed since they were cached are reloaded
+func (m *Manager) MapToImage(tileMap *common.TileMap) (*image.Paletted, error) {
+	m.refreshCache(tileMap.Refs)
+	if tileMap.Metatiles != nil {
+		m.refreshCache(tileMap.Metatiles.Refs)
 	}
-	return rawIndex + 1
+	return tileset.RenderMap(m, tileMap)
 }
 
-func writeTileToImage(image *image.Paletted, palette outPalette, tile []byte, x, y int) {
-	if len(tile) != common.BitsPerTile {
-		return
+func (m *Manager) GetBinaryPath(name, extension string, isTileData bool) string {
+	if m.out.IsStdout() {
+		return common.StdStream
 	}
+	return path.Join(m.out.GetBinaryPath(isTileData), name+extension)
+}
 
-	for row := 0; row < common.TileSizePx; row++ {
-		for column := 0; column < common.TileSizePx; column++ {
-			image.SetColorIndex(x+column, y+row, palette.getColorIndex(tile[row*common.TileSizePx+column]))
-		}
+func (m *Manager) getOutPath(name, extension string, isTileData bool) string {
+	if m.out.IsStdout() {
+		return common.StdStream
 	}
+	isJSON := extension == common.ExtensionJSON
+	return path.Join(m.out.GetOutputPath(isTileData, isJSON), name+extension)
 }
@@ -0,0 +1,34 @@
+package file_manager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportTileData(t *testing.T) {
+	dir := t.TempDir()
+	tiles := &common.Tiles{Data: [][]byte{bytes.Repeat([]byte{2}, common.BitsPerTile)}}
+	cfg := &common.Config{Output: common.Output{Directory: dir, Type: common.OutputRaw}}
+
+	manager := NewManager(cfg)
+	encoded, err := manager.WriteTileData(tiles, "a")
+	assert.NoError(t, err)
+	assert.NoError(t, manager.ExportTileData(tiles, encoded, "a"))
+	written, err := os.ReadFile(filepath.Join(dir, "a.chr"))
+	assert.NoError(t, err)
+	exported, err := os.ReadFile(filepath.Join(dir, "a.2bpp"))
+	assert.NoError(t, err)
+	assert.Equal(t, written, exported)
+
+	// Color 2 can't be packed to 1bpp, so the tiles are only encoded if something is exported
+	cfg.TileFormat = common.TileFormat1bpp
+	assert.Error(t, NewManager(cfg).ExportTileData(tiles, nil, "b"))
+	cfg.Output.Type = common.OutputPNG
+	assert.NoError(t, NewManager(cfg).ExportTileData(tiles, nil, "b"))
+	assert.NoFileExists(t, filepath.Join(dir, "b.1bpp"))
+}
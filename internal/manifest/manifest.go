@@ -0,0 +1,230 @@
+// Package manifest records inputs and outputs of every processed entry, so that entries with unchanged inputs
+// can be skipped by the next run
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/valyala/fastjson"
+)
+
+// FileName of the manifest in the output directory
+const FileName = ".tileset_manager.json"
+
+const (
+	version  = 1
+	keyVer   = "version"
+	keyEntry = "entries"
+	keySet   = "settings"
+	keyIn    = "inputs"
+	keyOut   = "outputs"
+)
+
+// Entry describes the last successful build of an entry. Settings is a hash of everything that affects the output
+// besides input files, e.g. the palette and the output type. Inputs map file paths to content hashes
+type Entry struct {
+	Settings string
+	Inputs   map[string]string
+	Outputs  []string
+}
+
+// Manifest is safe for concurrent use
+type Manifest struct {
+	mutex   sync.Mutex
+	path    string
+	entries map[string]Entry
+	// Content hashes of files computed during this run
+	hashes map[string]string
+}
+
+// Load reads the manifest, a missing or outdated manifest is treated as empty
+func Load(path string) (*Manifest, error) {
+	m := &Manifest{
+		path:    path,
+		entries: map[string]Entry{},
+		hashes:  map[string]string{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, common.Wrap(err, "could not read manifest", path)
+	}
+	json, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return nil, common.Wrap(err, "could not parse manifest", path)
+	}
+	if json.GetInt(keyVer) != version {
+		return m, nil
+	}
+
+	json.GetObject(keyEntry).Visit(func(key []byte, v *fastjson.Value) {
+		entry := Entry{
+			Settings: string(v.GetStringBytes(keySet)),
+			Inputs:   map[string]string{},
+		}
+		v.GetObject(keyIn).Visit(func(file []byte, hash *fastjson.Value) {
+			entry.Inputs[string(file)] = string(hash.GetStringBytes())
+		})
+		for _, out := range v.GetArray(keyOut) {
+			entry.Outputs = append(entry.Outputs, string(out.GetStringBytes()))
+		}
+		m.entries[string(key)] = entry
+	})
+	return m, nil
+}
+
+// Hash returns a hex-encoded SHA-256 of data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashFile returns the hash of the file contents, each file is only read once during a run
+// unless it is an output of a recorded entry
+func (m *Manifest) HashFile(path string) (string, error) {
+	m.mutex.Lock()
+	hash, ok := m.hashes[path]
+	m.mutex.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash = Hash(data)
+
+	m.mutex.Lock()
+	m.hashes[path] = hash
+	m.mutex.Unlock()
+	return hash, nil
+}
+
+// UpToDate reports whether the entry was built with the same settings and inputs and all of its outputs exist
+func (m *Manifest) UpToDate(key, settings string) bool {
+	m.mutex.Lock()
+	entry, ok := m.entries[key]
+	m.mutex.Unlock()
+	if !ok || entry.Settings != settings {
+		return false
+	}
+
+	for file, hash := range entry.Inputs {
+		current, err := m.HashFile(file)
+		if err != nil || current != hash {
+			return false
+		}
+	}
+	for _, file := range entry.Outputs {
+		if _, err := os.Stat(file); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Record replaces the entry with the result of a successful build
+func (m *Manifest) Record(key, settings string, inputs, outputs []string) error {
+	entry := Entry{
+		Settings: settings,
+		Inputs:   make(map[string]string, len(inputs)),
+		Outputs:  outputs,
+	}
+
+	m.mutex.Lock()
+	// Outputs might be inputs of other entries
+	for _, file := range outputs {
+		delete(m.hashes, file)
+	}
+	m.mutex.Unlock()
+
+	for _, file := range inputs {
+		hash, err := m.HashFile(file)
+		if err != nil {
+			return common.Wrap(err, "could not hash input file", file)
+		}
+		entry.Inputs[file] = hash
+	}
+
+	m.mutex.Lock()
+	m.entries[key] = entry
+	m.mutex.Unlock()
+	return nil
+}
+
+// Inputs returns input files of the entry recorded by the last build
+func (m *Manifest) Inputs(key string) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return sortedKeys(m.entries[key].Inputs)
+}
+
+// Forget drops the hashes computed for the files, so that they are read again. Used when files change during a run
+func (m *Manifest) Forget(files ...string) {
+	m.mutex.Lock()
+	for _, file := range files {
+		delete(m.hashes, file)
+	}
+	m.mutex.Unlock()
+}
+
+// Remove forgets the entry, so that it is rebuilt by the next run
+func (m *Manifest) Remove(key string) {
+	m.mutex.Lock()
+	delete(m.entries, key)
+	m.mutex.Unlock()
+}
+
+// Save writes the manifest with sorted keys, so that it can be diffed
+func (m *Manifest) Save() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	arena := &fastjson.Arena{}
+	entries := arena.NewObject()
+	for _, key := range sortedKeys(m.entries) {
+		entry := m.entries[key]
+		inputs := arena.NewObject()
+		for _, file := range sortedKeys(entry.Inputs) {
+			inputs.Set(file, arena.NewString(entry.Inputs[file]))
+		}
+		outputs := arena.NewArray()
+		for i, file := range entry.Outputs {
+			outputs.SetArrayItem(i, arena.NewString(file))
+		}
+
+		value := arena.NewObject()
+		value.Set(keySet, arena.NewString(entry.Settings))
+		value.Set(keyIn, inputs)
+		value.Set(keyOut, outputs)
+		entries.Set(key, value)
+	}
+
+	json := arena.NewObject()
+	json.Set(keyVer, arena.NewNumberInt(version))
+	json.Set(keyEntry, entries)
+	err := os.WriteFile(m.path, json.MarshalTo(nil), 0666)
+	if err != nil {
+		return common.Wrap(err, "could not write manifest", m.path)
+	}
+	return nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,37 @@
+package manifest
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	input, output := path.Join(dir, "a.chr"), path.Join(dir, "a.png")
+	manifestPath := path.Join(dir, FileName)
+	assert.NoError(t, os.WriteFile(input, []byte{1, 2, 3}, 0666))
+	assert.NoError(t, os.WriteFile(output, []byte{}, 0666))
+
+	m, err := Load(manifestPath)
+	assert.NoError(t, err)
+	assert.False(t, m.UpToDate("auto:a.chr", "settings"))
+	assert.NoError(t, m.Record("auto:a.chr", "settings", []string{input}, []string{output}))
+	assert.NoError(t, m.Save())
+
+	m, err = Load(manifestPath)
+	assert.NoError(t, err)
+	assert.True(t, m.UpToDate("auto:a.chr", "settings"))
+	assert.False(t, m.UpToDate("auto:a.chr", "other settings"))
+
+	assert.NoError(t, os.WriteFile(input, []byte{1, 2, 4}, 0666))
+	m, err = Load(manifestPath)
+	assert.NoError(t, err)
+	assert.False(t, m.UpToDate("auto:a.chr", "settings"))
+
+	assert.NoError(t, m.Record("auto:a.chr", "settings", []string{input}, []string{output}))
+	assert.NoError(t, os.Remove(output))
+	assert.False(t, m.UpToDate("auto:a.chr", "settings"))
+}
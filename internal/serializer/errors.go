@@ -0,0 +1,140 @@
+package serializer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/validator"
+	"github.com/Onlymiind/tileset_manager/schemas"
+	"github.com/valyala/fastjson"
+)
+
+// ParseError describes a single problem found in a JSON file.
+// Pointer is a JSON pointer (RFC 6901) to the offending value, e.g. /metatiles/12/tr
+type ParseError struct {
+	File    string
+	Pointer string
+	Reason  string
+}
+
+func (e ParseError) Error() string {
+	msg := e.Reason
+	if len(e.Pointer) != 0 {
+		msg = e.Pointer + ": " + msg
+	}
+	if len(e.File) != 0 {
+		msg = e.File + ": " + msg
+	}
+	return msg
+}
+
+// ParseErrors holds every problem found in a file
+type ParseErrors []ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for i := range e {
+		msgs = append(msgs, e[i].Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// parser collects problems found in a single file.
+// In strict mode any problem fails parsing, otherwise invalid values are skipped or replaced with defaults
+// and only problems reported with fail() are fatal
+type parser struct {
+	file   string
+	strict bool
+	fatal  bool
+	errors ParseErrors
+}
+
+func (p *parser) report(pointer string, format string, args ...any) {
+	p.errors = append(p.errors, ParseError{
+		File:    p.file,
+		Pointer: pointer,
+		Reason:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (p *parser) fail(pointer string, format string, args ...any) {
+	p.fatal = true
+	p.report(pointer, format, args...)
+}
+
+func (p *parser) err() error {
+	if len(p.errors) == 0 || !(p.strict || p.fatal) {
+		return nil
+	}
+	return p.errors
+}
+
+// pointer builds a JSON pointer from object keys and array indexes
+func pointer(base string, tokens ...any) string {
+	var builder strings.Builder
+	builder.WriteString(base)
+	for _, token := range tokens {
+		builder.WriteByte('/')
+		switch t := token.(type) {
+		case int:
+			builder.WriteString(strconv.Itoa(t))
+		case string:
+			builder.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(t))
+		}
+	}
+	return builder.String()
+}
+
+// validate checks json against one of the embedded schemas, every violation is reported
+func (p *parser) validate(json *fastjson.Value, schema string) {
+	v, err := validator.Embedded()
+	if err != nil {
+		p.fail("", "could not load schemas: %s", err.Error())
+		return
+	}
+	for _, e := range v.Validate(schema, json) {
+		p.report(e.Pointer, "%s", e.Reason)
+	}
+}
+
+// ValidateFile checks a config or a data file against its schema, see SchemaFor
+func ValidateFile(path string) error {
+	data, err := common.ReadFile(path)
+	if err != nil {
+		return common.Wrap(err, "could not read file", path)
+	}
+	json, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return common.Wrap(err, "could not parse json", path)
+	}
+
+	p := &parser{file: path, strict: true}
+	p.validate(json, SchemaFor(path, json))
+	return p.err()
+}
+
+// SchemaFor returns name of the embedded schema for the file. The schema is chosen by file extension,
+// then by the "type" field, files with neither are treated as configs
+func SchemaFor(path string, json *fastjson.Value) string {
+	switch {
+	case strings.HasSuffix(path, common.ExtensionTileJSON):
+		return schemas.Tiles
+	case strings.HasSuffix(path, common.ExtensionMetatileJSON):
+		return schemas.Metatiles
+	case strings.HasSuffix(path, common.ExtensionMapJSON):
+		return schemas.Map
+	}
+
+	switch string(json.GetStringBytes(fileType)) {
+	case typeTileData:
+		return schemas.Tiles
+	case typeMetatileData:
+		return schemas.Metatiles
+	case typeMapData:
+		return schemas.Map
+	default:
+		return schemas.Config
+	}
+}
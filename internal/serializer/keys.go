@@ -1,5 +1,7 @@
 package serializer
 
+import "github.com/Onlymiind/tileset_manager/internal/common"
+
 const (
 	out          = "output"
 	outType      = "type"
@@ -7,6 +9,7 @@ const (
 	imgDir       = "img_directory"
 	jsonDir      = "json_directory"
 	tileDir      = "tile_directory"
+	binDir       = "bin_directory"
 	emptyTile    = "empty_tile"
 	convertToPng = "convert_to_png"
 	manual       = "manual"
@@ -19,6 +22,28 @@ const (
 	mtiles       = "metatiles"
 	absentTiles  = "absent_tiles"
 	cacheSize    = "cache_size"
+	compile      = "compile"
+	image        = "image"
+	dedup        = "dedup"
+	attributes   = "attributes"
+	flips        = "flips"
+	attrData     = "attribute_data"
+	bank1Data    = "bank1_tile_data"
+	cgbPalettes  = "cgb_palettes"
+	width        = "width"
+	height       = "height"
+	cells        = "cells"
+	cellData     = "data"
+	addressing   = "addressing"
+	mtileWidth   = "metatile_width"
+	mtileHeight  = "metatile_height"
+	layout       = "layout"
+	strict       = "strict"
+	jobs         = "jobs"
+	tileFormat   = "tile_format"
+	properties   = "properties"
+	compression  = "compression"
+	bitColors    = "1bpp_colors"
 
 	topLeft     = "tl"
 	topRight    = "tr"
@@ -29,4 +54,96 @@ const (
 
 	typeTileData     = "tiles"
 	typeMetatileData = "mtiles"
+	typeMapData      = "map"
+
+	bankSeparator = "@"
+
+	dedupExact = "exact"
+	dedupFlip  = "flip"
+
+	layoutRowMajor    = "row_major"
+	layoutColumnMajor = "column_major"
+	layoutPlanar      = "planar"
+
+	addressing8000 = "8000"
+	addressing8800 = "8800"
+
+	tileFormatGB    = "gb"
+	tileFormatNES   = "nes"
+	tileFormatSNES4 = "snes4"
+	tileFormatPCE   = "pce"
+	tileFormatSNES8 = "snes8"
+	tileFormatGBA4  = "gba4"
+	tileFormatGBA8  = "gba8"
+	tileFormat1bpp  = "1bpp"
+
+	compressionNone = "none"
+	compressionRLE  = "rle"
+	compressionPB16 = "pb16"
+	compressionLZSS = "lzss"
+
+	outputPNGOnly    = "png_only"
+	outputJSONOnly   = "json_only"
+	outputPNGAndJSON = "png_and_json"
+	outputPNG        = "png"
+	outputJSON       = "json"
+	outputASM        = "asm"
+	outputC          = "c"
+	outputRaw        = "2bpp"
+	outputTiled      = "tiled"
+)
+
+var (
+	outputTypes = map[string]common.OutputType{
+		outputPNGOnly:    common.OutputPNG,
+		outputJSONOnly:   common.OutputJSON,
+		outputPNGAndJSON: common.OutputPNG | common.OutputJSON,
+		outputPNG:        common.OutputPNG,
+		outputJSON:       common.OutputJSON,
+		outputASM:        common.OutputASM,
+		outputC:          common.OutputC,
+		outputRaw:        common.OutputRaw,
+		outputTiled:      common.OutputTiled,
+	}
+	layouts = map[string]common.MetatileLayout{
+		layoutRowMajor:    common.LayoutRowMajor,
+		layoutColumnMajor: common.LayoutColumnMajor,
+		layoutPlanar:      common.LayoutPlanar,
+	}
+	addressingModes = map[string]common.AddressingMode{
+		addressing8000: common.Addressing8000,
+		addressing8800: common.Addressing8800,
+	}
+	tileFormats = map[string]common.TileFormat{
+		tileFormatGB:    common.TileFormatGB,
+		tileFormatNES:   common.TileFormatNES,
+		tileFormatSNES4: common.TileFormatSNES4,
+		tileFormatPCE:   common.TileFormatPCE,
+		tileFormatSNES8: common.TileFormatSNES8,
+		tileFormatGBA4:  common.TileFormatGBA4,
+		tileFormatGBA8:  common.TileFormatGBA8,
+		tileFormat1bpp:  common.TileFormat1bpp,
+	}
+	compressions = map[string]common.Compression{
+		compressionNone: common.CompressionNone,
+		compressionRLE:  common.CompressionRLE,
+		compressionPB16: common.CompressionPB16,
+		compressionLZSS: common.CompressionLZSS,
+	}
+	compileTypes = map[string]common.CompileType{
+		typeTileData:     common.CompileTiles,
+		typeMetatileData: common.CompileMetatiles,
+	}
+	// Values are DetectFlips of common.Compile and common.Manual
+	dedupModes = map[string]bool{
+		dedupExact: false,
+		dedupFlip:  true,
+	}
+	// Values of the "flips" array written before metatiles had attributes
+	flipNames = map[string]common.TileFlip{
+		"":   0,
+		"x":  common.FlipX,
+		"y":  common.FlipY,
+		"xy": common.FlipXY,
+	}
 )
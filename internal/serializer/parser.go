@@ -5,20 +5,26 @@ import (
 	"errors"
 	"fmt"
 	"image/color"
-	"os"
 	"strconv"
 	"strings"
 
 	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/schemas"
 	"github.com/valyala/fastjson"
 )
 
-func ParseTileData(path string) (*common.Tiles, error) {
-	data, err := os.ReadFile(path)
+// ParseTileData parses a .tile.json file. In strict mode undecodable tiles and invalid colors are errors,
+// otherwise they are skipped or replaced with black
+func ParseTileData(path string, strict bool) (*common.Tiles, error) {
+	data, err := common.ReadFile(path)
 	if err != nil {
 		return nil, common.Wrap(err, "could not read file", path)
 	}
+	return ParseTileDataBytes(path, data, strict)
+}
 
+// ParseTileDataBytes parses contents of a .tile.json file, path is only used in error messages
+func ParseTileDataBytes(path string, data []byte, strict bool) (*common.Tiles, error) {
 	json, err := fastjson.ParseBytes(data)
 	if err != nil {
 		return nil, common.Wrap(err, "could not parse json", path)
@@ -27,94 +33,208 @@ func ParseTileData(path string) (*common.Tiles, error) {
 		return nil, fmt.Errorf("wrong file type: expected type=%s, got %s", typeTileData, ftype)
 	}
 
-	arr := json.GetArray(tiles)
+	p := &parser{file: path, strict: strict}
+	if strict {
+		p.validate(json, schemas.Tiles)
+		if err := p.err(); err != nil {
+			return nil, err
+		}
+	}
+	arr := p.getArray(json, "", tiles)
 	result := &common.Tiles{
-		Data: make([][]byte, 0, len(arr)),
+		Data:    make([][]byte, 0, len(arr)),
+		Palette: p.parseColors(json, ""),
 	}
+	colors := max(len(result.Palette), common.CGBPaletteSize)
 	for i := range arr {
-		decoded, err := base64.StdEncoding.DecodeString(string(arr[i].GetStringBytes()))
-		if err == nil {
-			result.Data = append(result.Data, decoded)
-			result.Size += common.MemorySizeFrom(float64(len(decoded)), common.Bytes)
+		ptr := pointer("", tiles, i)
+		str, ok := p.requireString(arr[i], ptr)
+		if !ok {
+			continue
 		}
-	}
-	palette := json.GetArray(palette)
-	result.Palette = make([]color.Color, 0, len(palette))
-	for i := range palette {
-		result.Palette = append(result.Palette, parseColor(string(palette[i].GetStringBytes())))
+		decoded, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			p.report(ptr, "invalid base64: %s", err.Error())
+			continue
+		}
+		// Tiles are stored as color indexes of each pixel
+		if len(decoded) != common.BitsPerTile {
+			p.report(ptr, "expected %d pixels, got %d", common.BitsPerTile, len(decoded))
+			continue
+		}
+		if pixel := InvalidPixel(decoded, colors); pixel >= 0 {
+			p.report(ptr, "invalid color index %d of pixel %d", decoded[pixel], pixel)
+			continue
+		}
+		result.Data = append(result.Data, decoded)
+		result.Size += common.MemorySizeFrom(float64(len(decoded)), common.Bytes)
 	}
 
+	if err := p.err(); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
+// ParseConfig parses a config file. Parsing is strict unless the config sets "strict": false,
+// the same mode is then used for data files
 func ParseConfig(cfgPath string) (*common.Config, error) {
-	data, err := os.ReadFile(cfgPath)
+	data, err := common.ReadFile(cfgPath)
 	if err != nil {
 		return nil, common.Wrap(err, "could not read config file")
 	}
+	return ParseConfigBytes(cfgPath, data)
+}
 
+// ParseConfigBytes parses contents of a config file, cfgPath is only used in error messages.
+// Parsing "{}" gives the default config
+func ParseConfigBytes(cfgPath string, data []byte) (*common.Config, error) {
 	cfgJSON, err := fastjson.ParseBytes(data)
 	if err != nil {
 		return nil, common.Wrap(err, "could not parse config")
 	}
 
-	cfg := &common.Config{}
-	cfg.Auto = string(cfgJSON.GetStringBytes(auto))
+	p := &parser{file: cfgPath, strict: true}
+	if value := cfgJSON.Get(strict); value != nil {
+		isStrict, err := value.Bool()
+		if err != nil {
+			p.report(pointer("", strict), "expected a boolean")
+		} else {
+			p.strict = isStrict
+		}
+	}
+	if p.strict {
+		p.validate(cfgJSON, schemas.Config)
+		if err := p.err(); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &common.Config{Strict: p.strict}
+	cfg.Auto = p.getString(cfgJSON.Get(auto), pointer("", auto))
 
-	output := cfgJSON.GetObject(out)
+	output := cfgJSON.Get(out)
 	cfg.Output = common.Output{
-		Directory:     string(output.Get(outDir).GetStringBytes()),
-		Type:          getOutputType(string(output.Get(outType).GetStringBytes())),
-		ImgDirectory:  string(output.Get(imgDir).GetStringBytes()),
-		JSONDirectory: string(output.Get(jsonDir).GetStringBytes()),
-		TileDirectory: string(output.Get(tileDir).GetStringBytes()),
+		Directory:     p.getString(output.Get(outDir), pointer("", out, outDir)),
+		Type:          p.getOutputType(output.Get(outType), pointer("", out, outType)),
+		ImgDirectory:  p.getString(output.Get(imgDir), pointer("", out, imgDir)),
+		JSONDirectory: p.getString(output.Get(jsonDir), pointer("", out, jsonDir)),
+		TileDirectory: p.getString(output.Get(tileDir), pointer("", out, tileDir)),
+		BinDirectory:  p.getString(output.Get(binDir), pointer("", out, binDir)),
 	}
 
-	cfgJSON.GetObject(emptyTile).Visit(func(idStr []byte, val *fastjson.Value) {
-		ref, err := parseTileRef(string(idStr), string(val.GetStringBytes()))
-		if err == nil {
-			cfg.EmptyTile = *ref
-		}
-	})
-
-	cacheSize := cfgJSON.GetInt(cacheSize)
-	if cacheSize <= 0 {
-		cacheSize = common.DeafultCacheSizeKB
+	emptyTileRefs := common.NewTileRefs()
+	p.parseTileRefs(cfgJSON.Get(emptyTile), pointer("", emptyTile), &emptyTileRefs)
+	if emptyTileRefs.Size() != 0 {
+		cfg.EmptyTile = emptyTileRefs.Begin().GetValue()
 	}
 
-	cfg.CacheSize = common.MemorySizeFrom(float64(cacheSize), common.Kilobytes)
+	cacheSizeKB := common.DeafultCacheSizeKB
+	if value := cfgJSON.Get(cacheSize); value != nil {
+		size, err := value.Int()
+		if err != nil || size <= 0 {
+			p.report(pointer("", cacheSize), "expected a positive integer")
+		} else {
+			cacheSizeKB = size
+		}
+	}
 
-	palette := cfgJSON.GetArray(palette)
-	cfg.Palette = make([]color.Color, 0, len(palette))
-	for i := range palette {
-		cfg.Palette = append(cfg.Palette, parseColor(string(palette[i].GetStringBytes())))
+	cfg.CacheSize = common.MemorySizeFrom(float64(cacheSizeKB), common.Kilobytes)
+	if value := cfgJSON.Get(jobs); value != nil {
+		count, err := value.Int()
+		if err != nil || count <= 0 {
+			p.report(pointer("", jobs), "expected a positive integer")
+		} else {
+			cfg.Jobs = count
+		}
 	}
+	cfg.MetatileFormat = p.parseMetatileFormat(cfgJSON, "", common.MetatileFormat{
+		Size: common.MetatileSize{Width: common.DefaultMetatileSize, Height: common.DefaultMetatileSize},
+	})
+	cfg.TileFormat = p.parseTileFormat(cfgJSON, "", common.TileFormatGB)
+	cfg.Compression = p.parseCompression(cfgJSON, "", common.CompressionNone)
+	cfg.BitColors = p.parseBitColors(cfgJSON, "")
+
+	cfg.Palette = p.parseColors(cfgJSON, "")
+	cfg.CGBPalettes = p.parseCGBPalettes(cfgJSON, "")
 
-	convert := cfgJSON.GetArray(convertToPng)
+	convert := p.getArray(cfgJSON, "", convertToPng)
 	cfg.ConvertToPng = make([]string, 0, len(convert))
 	for i := range convert {
-		cfg.ConvertToPng = append(cfg.ConvertToPng, string(convert[i].GetStringBytes()))
+		cfg.ConvertToPng = append(cfg.ConvertToPng, p.getString(convert[i], pointer("", convertToPng, i)))
 	}
 
-	manual := cfgJSON.GetArray(manual)
-	cfg.Manual = make([]common.Manual, 0, len(manual))
-	for i := range manual {
-		cfg.Manual = append(cfg.Manual, common.Manual{
-			TileData:     string(manual[i].GetStringBytes(tileData)),
-			MetatileData: string(manual[i].GetStringBytes(mtileData)),
-			Name:         string(manual[i].GetStringBytes(name)),
-		})
+	manualEntries := p.getArray(cfgJSON, "", manual)
+	cfg.Manual = make([]common.Manual, 0, len(manualEntries))
+	for i, value := range manualEntries {
+		ptr := pointer("", manual, i)
+		entry := common.Manual{
+			TileData:      p.getString(value.Get(tileData), pointer(ptr, tileData)),
+			MetatileData:  p.getString(value.Get(mtileData), pointer(ptr, mtileData)),
+			AttributeData: p.getString(value.Get(attrData), pointer(ptr, attrData)),
+			Bank1TileData: p.getString(value.Get(bank1Data), pointer(ptr, bank1Data)),
+			Name:          p.getString(value.Get(name), pointer(ptr, name)),
+			Format:        p.parseMetatileFormat(value, ptr, cfg.MetatileFormat),
+			TileFormat:    p.parseTileFormat(value, ptr, cfg.TileFormat),
+			Compression:   p.parseCompression(value, ptr, cfg.Compression),
+		}
+		if len(entry.TileData) == 0 {
+			p.report(pointer(ptr, tileData), "tile data file is required")
+		}
+		entry.Dedup, entry.DetectFlips = p.parseDedup(value, ptr)
+		cfg.Manual = append(cfg.Manual, entry)
 	}
 
+	compileEntries := p.getArray(cfgJSON, "", compile)
+	cfg.Compile = make([]common.Compile, 0, len(compileEntries))
+	for i, value := range compileEntries {
+		ptr := pointer("", compile, i)
+		entry := common.Compile{
+			Image:       p.getString(value.Get(image), pointer(ptr, image)),
+			Name:        p.getString(value.Get(name), pointer(ptr, name)),
+			Type:        p.getCompileType(value.Get(fileType), pointer(ptr, fileType)),
+			Format:      p.parseMetatileFormat(value, ptr, cfg.MetatileFormat),
+			TileFormat:  p.parseTileFormat(value, ptr, cfg.TileFormat),
+			Compression: p.parseCompression(value, ptr, cfg.Compression),
+		}
+		if len(entry.Image) == 0 {
+			p.report(pointer(ptr, image), "image is required")
+		}
+		_, entry.DetectFlips = p.parseDedup(value, ptr)
+		cfg.Compile = append(cfg.Compile, entry)
+	}
+
+	if err := p.err(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
-func ParseMetatileData(path string) (*common.Metatiles, error) {
-	data, err := os.ReadFile(path)
+// parseDedup returns whether deduplication is set and whether it detects flips
+func (p *parser) parseDedup(value *fastjson.Value, ptr string) (bool, bool) {
+	dedupType := p.getString(value.Get(dedup), pointer(ptr, dedup))
+	if len(dedupType) == 0 {
+		return false, false
+	}
+	detectFlips, ok := dedupModes[dedupType]
+	if !ok {
+		p.report(pointer(ptr, dedup), "unknown deduplication mode %q", dedupType)
+	}
+	return ok, detectFlips
+}
+
+// ParseMetatileData parses a .mtile.json file. In strict mode invalid tile references, tile indexes and colors are errors,
+// otherwise invalid references and metatiles are skipped
+func ParseMetatileData(path string, strict bool) (*common.Metatiles, error) {
+	data, err := common.ReadFile(path)
 	if err != nil {
 		return nil, common.Wrap(err, "could not read file", path)
 	}
+	return ParseMetatileDataBytes(path, data, strict)
+}
 
+// ParseMetatileDataBytes parses contents of a .mtile.json file, path is only used in error messages
+func ParseMetatileDataBytes(path string, data []byte, strict bool) (*common.Metatiles, error) {
 	result := common.NewMetatiles()
 
 	parsed, err := fastjson.ParseBytes(data)
@@ -125,76 +245,505 @@ func ParseMetatileData(path string) (*common.Metatiles, error) {
 		return nil, fmt.Errorf("wrong file type: expected type=%s, got %s", typeMetatileData, ftype)
 	}
 
-	palette := parsed.GetArray(palette)
-	result.Palette = make([]color.Color, 0, len(palette))
-	for i := range palette {
-		result.Palette = append(result.Palette, parseColor(string(palette[i].GetStringBytes())))
+	p := &parser{file: path, strict: strict}
+	if strict {
+		p.validate(parsed, schemas.Metatiles)
+		if err := p.err(); err != nil {
+			return nil, err
+		}
 	}
+	result.Palette = p.parseColors(parsed, "")
+	result.CGBPalettes = p.parseCGBPalettes(parsed, "")
 
-	parsed.GetObject(tiles).Visit(func(ids []byte, refStr *fastjson.Value) {
-		ref, err := parseTileRef(string(ids), string(refStr.GetStringBytes()))
-		if err == nil {
-			result.Refs.Insert(*ref)
-		}
-	})
-	absent := parsed.GetArray(absentTiles)
+	p.parseTileRefs(parsed.Get(tiles), pointer("", tiles), &result.Refs)
+	absent := p.getArray(parsed, "", absentTiles)
 	for i := range absent {
-		rng, err := parseIndexRange(string(absent[i].GetStringBytes()))
-		if err == nil {
-			result.AbsentTiles.Insert(rng)
+		ptr := pointer("", absentTiles, i)
+		str, ok := p.requireString(absent[i], ptr)
+		if !ok {
+			continue
 		}
+		rng, err := parseBankRange(str)
+		if err != nil {
+			p.report(ptr, "%s", err.Error())
+			continue
+		}
+		result.AbsentTiles.Insert(rng)
 	}
 
-	metatiles := parsed.GetArray(mtiles)
+	result.Size = p.parseMetatileSize(parsed, "", common.MetatileSize{Width: common.DefaultMetatileSize, Height: common.DefaultMetatileSize})
+	result.Addressing = p.parseAddressing(parsed, "", common.Addressing8000)
+
+	metatiles := p.getArray(parsed, "", mtiles)
 	result.Metatiles = make([]common.Metatile, 0, len(metatiles))
 	for i := range metatiles {
-		tl, err := strconv.ParseUint(string(metatiles[i].GetStringBytes(topLeft)), 16, 8)
-		if err != nil {
-			continue
+		mtile, ok := p.parseMetatile(metatiles[i], pointer("", mtiles, i), result.Size)
+		if ok {
+			result.Metatiles = append(result.Metatiles, *mtile)
 		}
-		tr, err := strconv.ParseUint(string(metatiles[i].GetStringBytes(topRight)), 16, 8)
-		if err != nil {
-			continue
+	}
+
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (p *parser) parseMetatile(value *fastjson.Value, ptr string, size common.MetatileSize) (*common.Metatile, bool) {
+	var indexes []*fastjson.Value
+	var pointers []string
+	if arr := value.GetArray(tiles); arr != nil {
+		for i := range arr {
+			indexes = append(indexes, arr[i])
+			pointers = append(pointers, pointer(ptr, tiles, i))
+		}
+	} else if size.IsDefault() {
+		for _, key := range []string{topLeft, topRight, bottomLeft, bottomRight} {
+			indexes = append(indexes, value.Get(key))
+			pointers = append(pointers, pointer(ptr, key))
+		}
+	}
+	if len(indexes) != size.TileCount() {
+		p.report(ptr, "expected %d tiles, got %d", size.TileCount(), len(indexes))
+		return nil, false
+	}
+
+	ok := true
+	mtile := &common.Metatile{Tiles: make([]uint8, 0, len(indexes))}
+	for i := range indexes {
+		index, valid := p.parseHexByte(indexes[i], pointers[i], "tile index")
+		ok = ok && valid
+		mtile.Tiles = append(mtile.Tiles, index)
+	}
+
+	mtileAttributes := value.GetArray(attributes)
+	if len(mtileAttributes) != 0 {
+		mtile.Attributes = make([]common.TileAttributes, len(mtile.Tiles))
+		if len(mtileAttributes) != len(mtile.Tiles) {
+			p.report(pointer(ptr, attributes), "expected %d attributes, got %d", len(mtile.Tiles), len(mtileAttributes))
+		}
+	}
+	for j := 0; j < len(mtileAttributes) && j < len(mtile.Attributes); j++ {
+		attr, valid := p.parseHexByte(mtileAttributes[j], pointer(ptr, attributes, j), "tile attributes")
+		ok = ok && valid
+		mtile.Attributes[j] = common.TileAttributes(attr)
+	}
+	// Older files store only flips, e.g. "flips": ["", "x", "y", "xy"], attributes take precedence
+	if mtileFlips := value.GetArray(flips); len(mtileFlips) != 0 && len(mtileAttributes) == 0 {
+		mtile.Attributes = make([]common.TileAttributes, len(mtile.Tiles))
+		if len(mtileFlips) > len(mtile.Tiles) {
+			p.report(pointer(ptr, flips), "expected at most %d flips, got %d", len(mtile.Tiles), len(mtileFlips))
+		}
+		for j := 0; j < len(mtileFlips) && j < len(mtile.Attributes); j++ {
+			flip, valid := flipNames[p.getString(mtileFlips[j], pointer(ptr, flips, j))]
+			if !valid {
+				p.report(pointer(ptr, flips, j), "invalid flip, expected \"\", \"x\", \"y\" or \"xy\"")
+			}
+			ok = ok && valid
+			mtile.Attributes[j] = common.TileAttributes(flip)
+		}
+	}
+
+	if props := value.Get(properties); props != nil {
+		mtile.Properties = p.parseProperties(props, pointer(ptr, properties))
+	}
+
+	return mtile, ok
+}
+
+// parseProperties keeps the order of the properties, numbers without a fraction or an exponent are integers
+func (p *parser) parseProperties(value *fastjson.Value, ptr string) []common.Property {
+	obj, err := value.Object()
+	if err != nil {
+		p.report(ptr, "expected an object")
+		return nil
+	}
+	var result []common.Property
+	obj.Visit(func(key []byte, value *fastjson.Value) {
+		prop := common.Property{Name: string(key)}
+		switch value.Type() {
+		case fastjson.TypeString:
+			prop.Value = string(value.GetStringBytes())
+		case fastjson.TypeNumber:
+			prop.Value = value.String()
+			prop.Type = common.PropertyFloat
+			if _, err := strconv.ParseInt(prop.Value, 10, 64); err == nil {
+				prop.Type = common.PropertyInt
+			}
+		case fastjson.TypeTrue, fastjson.TypeFalse:
+			prop.Type = common.PropertyBool
+			prop.Value = value.String()
+		default:
+			p.report(pointer(ptr, prop.Name), "expected a string, number or boolean")
+			return
+		}
+		result = append(result, prop)
+	})
+	return result
+}
+
+func (p *parser) parseMetatileSize(value *fastjson.Value, ptr string, defaultSize common.MetatileSize) common.MetatileSize {
+	size := defaultSize
+	size.Width = p.getDimension(value.Get(mtileWidth), pointer(ptr, mtileWidth), size.Width)
+	size.Height = p.getDimension(value.Get(mtileHeight), pointer(ptr, mtileHeight), size.Height)
+	return size
+}
+
+func (p *parser) getDimension(value *fastjson.Value, ptr string, defaultValue int) int {
+	if value == nil {
+		return defaultValue
+	}
+	dimension, err := value.Int()
+	if err != nil || dimension <= 0 {
+		p.report(ptr, "expected a positive integer")
+		return defaultValue
+	}
+	return dimension
+}
+
+func (p *parser) parseMetatileFormat(value *fastjson.Value, ptr string, defaultFormat common.MetatileFormat) common.MetatileFormat {
+	format := common.MetatileFormat{
+		Size:   p.parseMetatileSize(value, ptr, defaultFormat.Size),
+		Layout: defaultFormat.Layout,
+	}
+	if layoutType := p.getString(value.Get(layout), pointer(ptr, layout)); len(layoutType) != 0 {
+		mtileLayout, ok := layouts[layoutType]
+		if ok {
+			format.Layout = mtileLayout
+		} else {
+			p.report(pointer(ptr, layout), "unknown layout %q", layoutType)
+		}
+	}
+	format.Addressing = p.parseAddressing(value, ptr, defaultFormat.Addressing)
+	return format
+}
+
+func (p *parser) parseAddressing(value *fastjson.Value, ptr string, defaultMode common.AddressingMode) common.AddressingMode {
+	mode := p.getString(value.Get(addressing), pointer(ptr, addressing))
+	if len(mode) == 0 {
+		return defaultMode
+	}
+	result, ok := addressingModes[mode]
+	if !ok {
+		p.report(pointer(ptr, addressing), "unknown addressing mode %q", mode)
+		return defaultMode
+	}
+	return result
+}
+
+func (p *parser) parseTileFormat(value *fastjson.Value, ptr string, defaultFormat common.TileFormat) common.TileFormat {
+	formatName := p.getString(value.Get(tileFormat), pointer(ptr, tileFormat))
+	if len(formatName) == 0 {
+		return defaultFormat
+	}
+	result, ok := tileFormats[formatName]
+	if !ok {
+		p.report(pointer(ptr, tileFormat), "unknown tile format %q", formatName)
+		return defaultFormat
+	}
+	return result
+}
+
+func (p *parser) parseCompression(value *fastjson.Value, ptr string, defaultCompression common.Compression) common.Compression {
+	compressionName := p.getString(value.Get(compression), pointer(ptr, compression))
+	if len(compressionName) == 0 {
+		return defaultCompression
+	}
+	result, ok := compressions[compressionName]
+	if !ok {
+		p.report(pointer(ptr, compression), "unknown compression %q", compressionName)
+		return defaultCompression
+	}
+	return result
+}
+
+func (p *parser) parseBitColors(value *fastjson.Value, ptr string) common.BitColors {
+	arr := p.getArray(value, ptr, bitColors)
+	if arr == nil {
+		return common.DefaultBitColors
+	}
+	if len(arr) != len(common.BitColors{}) {
+		p.report(pointer(ptr, bitColors), "expected 2 color indexes, got %d", len(arr))
+		return common.DefaultBitColors
+	}
+
+	var result common.BitColors
+	for i := range arr {
+		index, err := arr[i].Int()
+		if err != nil || index < 0 || index >= common.MaxPaletteColors {
+			p.report(pointer(ptr, bitColors, i), "expected a color index from 0 to %d", common.MaxPaletteColors-1)
+			return common.DefaultBitColors
 		}
-		bl, err := strconv.ParseUint(string(metatiles[i].GetStringBytes(bottomLeft)), 16, 8)
+		result[i] = uint8(index)
+	}
+	if result[0] == result[1] {
+		p.report(pointer(ptr, bitColors), "colors of 0 and 1 bits must differ")
+		return common.DefaultBitColors
+	}
+	return result
+}
+
+// ParseMapData parses a .map.json file. Invalid cells are always an error, other problems are only errors in strict mode
+func ParseMapData(path string, strict bool) (*common.TileMap, error) {
+	data, err := common.ReadFile(path)
+	if err != nil {
+		return nil, common.Wrap(err, "could not read file", path)
+	}
+	return ParseMapDataBytes(path, data, strict)
+}
+
+// ParseMapDataBytes parses contents of a .map.json file, path is only used in error messages
+func ParseMapDataBytes(path string, data []byte, strict bool) (*common.TileMap, error) {
+
+	parsed, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return nil, common.Wrap(err, "could not parse map data", path)
+	}
+	if ftype := string(parsed.GetStringBytes(fileType)); len(ftype) == 0 || ftype != typeMapData {
+		return nil, fmt.Errorf("wrong file type: expected type=%s, got %s", typeMapData, ftype)
+	}
+
+	result := common.NewTileMap()
+	result.Width = parsed.GetInt(width)
+	result.Height = parsed.GetInt(height)
+	if result.Width <= 0 || result.Height <= 0 {
+		return nil, fmt.Errorf("invalid map size %dx%d: %s", result.Width, result.Height, path)
+	}
+
+	p := &parser{file: path, strict: strict}
+	if strict {
+		p.validate(parsed, schemas.Map)
+		if err := p.err(); err != nil {
+			return nil, err
+		}
+	}
+	result.Palette = p.parseColors(parsed, "")
+	result.CGBPalettes = p.parseCGBPalettes(parsed, "")
+
+	p.parseTileRefs(parsed.Get(tiles), pointer("", tiles), &result.Refs)
+	result.MetatileFile = p.getString(parsed.Get(mtiles), pointer("", mtiles))
+	result.CellFile = p.getString(parsed.Get(cellData), pointer("", cellData))
+	result.Addressing = p.parseAddressing(parsed, "", common.Addressing8000)
+	result.AttributeFile = p.getString(parsed.Get(attrData), pointer("", attrData))
+
+	cellsArr := p.getArray(parsed, "", cells)
+	result.Cells = make([]uint8, 0, len(cellsArr))
+	for i := range cellsArr {
+		index, ok := p.parseHexByte(cellsArr[i], pointer("", cells, i), "map cell")
+		if !ok {
+			p.fatal = true
+		}
+		result.Cells = append(result.Cells, index)
+	}
+	attrArr := p.getArray(parsed, "", attributes)
+	for i := range attrArr {
+		attr, ok := p.parseHexByte(attrArr[i], pointer("", attributes, i), "map cell attributes")
+		if !ok {
+			p.fatal = true
+		}
+		result.Attributes = append(result.Attributes, common.TileAttributes(attr))
+	}
+
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Output type is either a single value or an array of values
+func (p *parser) getOutputType(value *fastjson.Value, ptr string) common.OutputType {
+	if value == nil {
+		return common.DefaultOutputType
+	}
+	values := []*fastjson.Value{value}
+	pointers := []string{ptr}
+	if arr, err := value.Array(); err == nil {
+		values = arr
+		pointers = pointers[:0]
+		for i := range arr {
+			pointers = append(pointers, pointer(ptr, i))
+		}
+	}
+
+	var result common.OutputType
+	for i := range values {
+		outputType := p.getString(values[i], pointers[i])
+		flags, ok := outputTypes[outputType]
+		if !ok {
+			p.report(pointers[i], "unknown output type %q", outputType)
+		}
+		result |= flags
+	}
+
+	if result == 0 {
+		return common.DefaultOutputType
+	}
+	return result
+}
+
+func (p *parser) getCompileType(value *fastjson.Value, ptr string) common.CompileType {
+	t := p.getString(value, ptr)
+	if len(t) == 0 {
+		return common.CompileTiles
+	}
+	result, ok := compileTypes[t]
+	if !ok {
+		p.report(ptr, "unknown compile type %q", t)
+	}
+	return result
+}
+
+func (p *parser) parseColors(value *fastjson.Value, ptr string) []color.Color {
+	arr := p.getArray(value, ptr, palette)
+	result := make([]color.Color, 0, len(arr))
+	for i := range arr {
+		result = append(result, p.parseColor(arr[i], pointer(ptr, palette, i)))
+	}
+	return result
+}
+
+func (p *parser) parseCGBPalettes(value *fastjson.Value, ptr string) [][]color.Color {
+	arr := p.getArray(value, ptr, cgbPalettes)
+	if len(arr) > common.MaxCGBPalettes {
+		p.report(pointer(ptr, cgbPalettes), "expected at most %d palettes, got %d", common.MaxCGBPalettes, len(arr))
+		arr = arr[:common.MaxCGBPalettes]
+	}
+
+	result := make([][]color.Color, 0, len(arr))
+	for i := range arr {
+		colors, err := arr[i].Array()
 		if err != nil {
-			continue
+			p.report(pointer(ptr, cgbPalettes, i), "expected an array of colors")
+		}
+		if len(colors) > common.CGBPaletteSize {
+			p.report(pointer(ptr, cgbPalettes, i), "expected at most %d colors, got %d", common.CGBPaletteSize, len(colors))
+		}
+		plt := make([]color.Color, 0, common.CGBPaletteSize)
+		for j := 0; j < len(colors) && j < common.CGBPaletteSize; j++ {
+			plt = append(plt, p.parseColor(colors[j], pointer(ptr, cgbPalettes, i, j)))
+		}
+		result = append(result, plt)
+	}
+	return result
+}
+
+// Invalid colors are replaced with black
+func (p *parser) parseColor(value *fastjson.Value, ptr string) color.Color {
+	str, ok := p.requireString(value, ptr)
+	if !ok {
+		return color.Black
+	}
+	result, err := parseColor(str)
+	if err != nil {
+		p.report(ptr, "invalid color %q: %s", str, err.Error())
+		return color.Black
+	}
+	return result
+}
+
+// Overlapping refs are reported, the first one in the document is used
+func (p *parser) parseTileRefs(value *fastjson.Value, ptr string, refs *common.TileRefs) {
+	if value == nil {
+		return
+	}
+	obj, err := value.Object()
+	if err != nil {
+		p.report(ptr, "expected an object")
+		return
+	}
+	obj.Visit(func(ids []byte, refStr *fastjson.Value) {
+		refPtr := pointer(ptr, string(ids))
+		str, ok := p.requireString(refStr, refPtr)
+		if !ok {
+			return
 		}
-		br, err := strconv.ParseUint(string(metatiles[i].GetStringBytes(bottomRight)), 16, 8)
+		ref, err := parseTileRef(string(ids), str)
 		if err != nil {
-			continue
+			p.report(refPtr, "invalid tile reference: %s", err.Error())
+			return
 		}
-		mtile := common.Metatile{
-			TopLeft:     uint8(tl),
-			TopRight:    uint8(tr),
-			BottomLeft:  uint8(bl),
-			BottomRight: uint8(br),
+		err = refs.Insert(*ref)
+		if err != nil {
+			p.report(refPtr, "invalid tile reference: %s", err.Error())
 		}
-		result.Metatiles = append(result.Metatiles, mtile)
+	})
+}
+
+func (p *parser) parseHexByte(value *fastjson.Value, ptr string, what string) (uint8, bool) {
+	str, ok := p.requireString(value, ptr)
+	if !ok {
+		return 0, false
 	}
+	parsed, err := strconv.ParseUint(str, 16, 8)
+	if err != nil {
+		p.report(ptr, "invalid %s %q", what, str)
+		return 0, false
+	}
+	return uint8(parsed), true
+}
 
-	return result, nil
+// getString reports values that are present, but are not strings
+func (p *parser) getString(value *fastjson.Value, ptr string) string {
+	if value == nil {
+		return ""
+	}
+	str, err := value.StringBytes()
+	if err != nil {
+		p.report(ptr, "expected a string")
+		return ""
+	}
+	return string(str)
+}
+
+// requireString reports values that are missing or are not strings
+func (p *parser) requireString(value *fastjson.Value, ptr string) (string, bool) {
+	if value == nil {
+		p.report(ptr, "missing value")
+		return "", false
+	}
+	str, err := value.StringBytes()
+	if err != nil {
+		p.report(ptr, "expected a string")
+		return "", false
+	}
+	return string(str), true
+}
+
+func (p *parser) getArray(value *fastjson.Value, ptr, key string) []*fastjson.Value {
+	field := value.Get(key)
+	if field == nil {
+		return nil
+	}
+	arr, err := field.Array()
+	if err != nil {
+		p.report(pointer(ptr, key), "expected an array")
+		return nil
+	}
+	return arr
 }
 
-func getOutputType(t string) common.OutputType {
-	switch t {
-	case "png_only":
-		return common.IgnoreJSON
-	case "json_only":
-		return common.IgnorePNG
-	default:
-		return 0
+// InvalidPixel returns index of the first pixel with a color index outside of the palette or -1
+func InvalidPixel(tile []byte, colors int) int {
+	for i, pixel := range tile {
+		if int(pixel) >= colors {
+			return i
+		}
 	}
+	return -1
 }
 
-func parseColor(str string) color.Color {
+// Colors are either 24-bit RGB (rrggbb) or CGB 15-bit RGB555 (bgr word, e.g. 7fff)
+func parseColor(str string) (color.Color, error) {
+	if len(str) == 4 {
+		return parseRGB555(str)
+	}
 	if len(str) != 6 {
-		return color.Black
+		return nil, errors.New("expected 6 hex digits (rrggbb) or 4 hex digits (RGB555)")
 	}
 
 	val, err := strconv.ParseUint(str, 16, 24)
 	if err != nil {
-		return color.Black
+		return nil, errors.New("invalid hex number")
 	}
 
 	return color.RGBA{
@@ -202,7 +751,25 @@ func parseColor(str string) color.Color {
 		G: uint8((val >> 8) & 0xff),
 		B: uint8(val & 0xff),
 		A: 0xff,
+	}, nil
+}
+
+func parseRGB555(str string) (color.Color, error) {
+	val, err := strconv.ParseUint(str, 16, 15)
+	if err != nil {
+		return nil, errors.New("invalid RGB555 color")
+	}
+
+	expand := func(c uint64) uint8 {
+		c &= 0x1f
+		return uint8(c<<3 | c>>2)
 	}
+	return color.RGBA{
+		R: expand(val),
+		G: expand(val >> 5),
+		B: expand(val >> 10),
+		A: 0xff,
+	}, nil
 }
 
 func parseIndexRange(indexes string) (common.IndexRange, error) {
@@ -229,13 +796,31 @@ func parseIndexRange(indexes string) (common.IndexRange, error) {
 	return common.IndexRange{Start: uint8(start), End: uint8(end)}, nil
 }
 
+// parseBankRange parses an index range with an optional VRAM bank: <range>[@<bank>]
+func parseBankRange(str string) (common.BankRange, error) {
+	rngStr, bankStr, _ := strings.Cut(str, bankSeparator)
+	indexes, err := parseIndexRange(rngStr)
+	if err != nil {
+		return common.BankRange{}, common.Wrap(err, "could not parse tile range")
+	}
+	bank := uint8(0)
+	if len(bankStr) != 0 {
+		bankU64, err := strconv.ParseUint(bankStr, 10, 1)
+		if err != nil {
+			return common.BankRange{}, common.Wrap(err, "could not parse VRAM bank")
+		}
+		bank = uint8(bankU64)
+	}
+	return common.BankRange{Range: indexes, Bank: bank}, nil
+}
+
 func parseTileRef(tileRange, refStr string) (*common.TileRef, error) {
 	if len(tileRange) == 0 {
 		return nil, errors.New("empty tile range")
 	}
-	indexes, err := parseIndexRange(tileRange)
+	rng, err := parseBankRange(tileRange)
 	if err != nil {
-		return nil, common.Wrap(err, "could not parse tile range")
+		return nil, err
 	}
 
 	path, offsetStr, _ := strings.Cut(refStr, ":")
@@ -254,7 +839,8 @@ func parseTileRef(tileRange, refStr string) (*common.TileRef, error) {
 
 	return &common.TileRef{
 		File:   path,
-		Range:  indexes,
+		Range:  rng.Range,
 		Offset: offset,
+		Bank:   rng.Bank,
 	}, nil
 }
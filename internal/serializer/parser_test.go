@@ -0,0 +1,188 @@
+package serializer
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const invalidMetatileData = `{
+	"type": "mtiles",
+	"palette": ["ffffff", "aabbcg", "555555", "000000"],
+	"tiles": {"0:3": "tiles.chr", "zz": "tiles.chr"},
+	"metatiles": [
+		{"tl": "0", "tr": "1", "bl": "2", "br": "3"},
+		{"tl": "0", "tr": "1g", "bl": "2", "br": 3},
+		{"tl": "0"}
+	]
+}`
+
+func writeTestFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0666))
+	return path
+}
+
+func TestParseMetatileDataStrict(t *testing.T) {
+	path := writeTestFile(t, "test.mtile.json", invalidMetatileData)
+
+	_, err := ParseMetatileData(path, true)
+	errs, ok := err.(ParseErrors)
+	assert.True(t, ok)
+
+	pointers := []string{}
+	for i := range errs {
+		assert.Equal(t, path, errs[i].File)
+		pointers = append(pointers, errs[i].Pointer)
+	}
+	assert.ElementsMatch(t, []string{"/palette/1", "/tiles/zz", "/metatiles/1/tr", "/metatiles/1/br", "/metatiles/2"}, pointers)
+
+	mtiles, err := ParseMetatileData(path, false)
+	assert.NoError(t, err)
+	assert.Len(t, mtiles.Metatiles, 1)
+	assert.Equal(t, 1, mtiles.Refs.Size())
+	assert.Equal(t, color.Black, mtiles.Palette[1])
+}
+
+func TestParseProperties(t *testing.T) {
+	data := `{"type": "mtiles", "metatiles": [{"tl": "0", "tr": "1", "bl": "2", "br": "3",
+		"properties": {"collision": "ladder", "solid": false, "damage": 2, "friction": 0.5}}]}`
+	props := []common.Property{
+		{Name: "collision", Value: "ladder"},
+		{Name: "solid", Type: common.PropertyBool, Value: "false"},
+		{Name: "damage", Type: common.PropertyInt, Value: "2"},
+		{Name: "friction", Type: common.PropertyFloat, Value: "0.5"},
+	}
+	mtiles, err := ParseMetatileDataBytes("", []byte(data), true)
+	assert.NoError(t, err)
+	assert.Equal(t, props, mtiles.Metatiles[0].Properties)
+
+	serialized := SerializeMetatileData(nil, mtiles).MarshalTo(nil)
+	mtiles, err = ParseMetatileDataBytes("", serialized, true)
+	assert.NoError(t, err)
+	assert.Equal(t, props, mtiles.Metatiles[0].Properties)
+
+	_, err = ParseMetatileDataBytes("", []byte(`{"type": "mtiles", "metatiles": [{"tl": "0", "tr": "1", "bl": "2", "br": "3", "properties": {"a": [1]}}]}`), true)
+	assert.Error(t, err)
+}
+
+func TestParseFlips(t *testing.T) {
+	// Files written before metatiles had attributes only store flips
+	path := writeTestFile(t, "old.mtile.json", `{"type": "mtiles", "metatiles": [
+		{"tl": "0", "tr": "1", "bl": "2", "br": "3", "flips": ["", "x", "y", "xy"]},
+		{"tl": "0", "tr": "1", "bl": "2", "br": "3", "flips": ["x"], "attributes": ["0", "1", "2", "3"]}
+	]}`)
+	mtiles, err := ParseMetatileData(path, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.TileAttributes{0, common.TileAttributes(common.FlipX), common.TileAttributes(common.FlipY), common.TileAttributes(common.FlipXY)},
+		mtiles.Metatiles[0].Attributes)
+	assert.Equal(t, []common.TileAttributes{0, 1, 2, 3}, mtiles.Metatiles[1].Attributes)
+
+	_, err = ParseMetatileDataBytes("", []byte(`{"type": "mtiles", "metatiles": [{"tl": "0", "tr": "1", "bl": "2", "br": "3", "flips": ["z"]}]}`), true)
+	assert.Error(t, err)
+}
+
+func TestAbsentTiles(t *testing.T) {
+	data := `{"type": "mtiles", "absent_tiles": ["2", "0:1@1"], "metatiles": [{"tiles": ["0", "1", "1", "2"], "attributes": ["8", "8", "0", "0"]}]}`
+	absent := []common.BankRange{
+		{Range: common.IndexRange{Start: 2, End: 2}},
+		{Range: common.IndexRange{Start: 0, End: 1}, Bank: 1},
+	}
+	mtiles, err := ParseMetatileDataBytes("", []byte(data), true)
+	assert.NoError(t, err)
+	for _, rng := range absent {
+		assert.True(t, mtiles.AbsentTiles.Contains(rng))
+	}
+
+	serialized := SerializeMetatileData(nil, mtiles).MarshalTo(nil)
+	mtiles, err = ParseMetatileDataBytes("", serialized, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, mtiles.AbsentTiles.Size())
+	for _, rng := range absent {
+		assert.True(t, mtiles.AbsentTiles.Contains(rng))
+	}
+
+	_, err = ParseMetatileDataBytes("", []byte(`{"type": "mtiles", "absent_tiles": ["0@2"], "metatiles": []}`), true)
+	assert.Error(t, err)
+}
+
+func TestParseConfigStrict(t *testing.T) {
+	path := writeTestFile(t, "config.json", `{"palette": ["ffffff", "00000", "555555", "000000"], "output": {"type": "pgn"}}`)
+	_, err := ParseConfig(path)
+	assert.Len(t, err, 2)
+
+	path = writeTestFile(t, "config.json", `{"strict": false, "palette": ["ffffff", "00000", "555555", "000000"], "output": {"type": "pgn"}}`)
+	cfg, err := ParseConfig(path)
+	assert.NoError(t, err)
+	assert.False(t, cfg.Strict)
+}
+
+func TestParseTileFormat(t *testing.T) {
+	path := writeTestFile(t, "config.json", `{"tile_format": "nes", "compile": [{"image": "a.png", "tile_format": "snes"}]}`)
+	_, err := ParseConfig(path)
+	errs, ok := err.(ParseErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "/compile/0/tile_format", errs[0].Pointer)
+
+	path = writeTestFile(t, "config.json", `{
+		"tile_format": "nes",
+		"manual": [{"tile_data": "a.chr"}, {"tile_data": "b.chr", "tile_format": "gb"}]
+	}`)
+	cfg, err := ParseConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, common.DefaultBitColors, cfg.BitColors)
+	assert.Equal(t, common.TileFormatNES, cfg.TileFormat)
+	assert.Equal(t, common.TileFormatNES, cfg.Manual[0].TileFormat)
+	assert.Equal(t, common.TileFormatGB, cfg.Manual[1].TileFormat)
+}
+
+func TestParseBitColors(t *testing.T) {
+	cfg, err := ParseConfigBytes("", []byte(`{"1bpp_colors": [3, 1]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, common.BitColors{3, 1}, cfg.BitColors)
+
+	for _, invalid := range []string{`[1, 1]`, `[0, 256]`, `[0]`} {
+		_, err = ParseConfigBytes("", []byte(`{"1bpp_colors": `+invalid+`}`))
+		assert.Error(t, err, invalid)
+		cfg, err = ParseConfigBytes("", []byte(`{"strict": false, "1bpp_colors": `+invalid+`}`))
+		assert.NoError(t, err, invalid)
+		assert.Equal(t, common.DefaultBitColors, cfg.BitColors, invalid)
+	}
+
+	colors, err := ParseBitColors("0,2")
+	assert.NoError(t, err)
+	assert.Equal(t, common.BitColors{0, 2}, colors)
+	_, err = ParseBitColors("0,x")
+	assert.Error(t, err)
+}
+
+func TestPointer(t *testing.T) {
+	assert.Equal(t, "/metatiles/12/tr", pointer("", "metatiles", 12, "tr"))
+	assert.Equal(t, "/tiles/a~1b~0", pointer("/tiles", "a/b~"))
+}
+
+func TestParseOverlappingRefs(t *testing.T) {
+	path := writeTestFile(t, "test.mtile.json", `{
+		"type": "mtiles",
+		"tiles": {"0:7f": "tiles.chr", "10": "empty.chr", "10@1": "bank1.chr"},
+		"metatiles": [{"tl": "0", "tr": "1", "bl": "2", "br": "10"}]
+	}`)
+
+	_, err := ParseMetatileData(path, true)
+	errs, ok := err.(ParseErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "/tiles/10", errs[0].Pointer)
+
+	mtiles, err := ParseMetatileData(path, false)
+	assert.NoError(t, err)
+	ref, ok := mtiles.Refs.Find(0, 0x10)
+	assert.True(t, ok)
+	assert.Equal(t, "tiles.chr", ref.File)
+	assert.Equal(t, 2, mtiles.Refs.Size())
+}
@@ -38,7 +38,7 @@ func SerializeMetatileData(plt []color.Color, data *common.Metatiles) *fastjson.
 	absTiles := arena.NewArray()
 	i := 0
 	for it := data.AbsentTiles.Begin(); it != nil; it = it.Next() {
-		absTiles.SetArrayItem(i, arena.NewString(serializeTileRange(it.GetValue())))
+		absTiles.SetArrayItem(i, arena.NewString(serializeBankRange(it.GetValue())))
 		i++
 	}
 	result.Set(absentTiles, absTiles)
@@ -51,10 +51,18 @@ func SerializeMetatileData(plt []color.Color, data *common.Metatiles) *fastjson.
 
 	metatiles := arena.NewArray()
 	for i := range data.Metatiles {
-		metatiles.SetArrayItem(i, serializeMetatile(arena, data.Metatiles[i]))
+		metatiles.SetArrayItem(i, serializeMetatile(arena, data.Size, data.Metatiles[i]))
 	}
 	result.Set(mtiles, metatiles)
 
+	if !data.Size.IsDefault() {
+		result.Set(mtileWidth, arena.NewNumberInt(data.Size.Width))
+		result.Set(mtileHeight, arena.NewNumberInt(data.Size.Height))
+	}
+	if data.Addressing == common.Addressing8800 {
+		result.Set(addressing, arena.NewString(addressing8800))
+	}
+
 	if len(data.Palette) != 0 {
 		paletteObj := arena.NewArray()
 		for i := range data.Palette {
@@ -63,15 +71,117 @@ func SerializeMetatileData(plt []color.Color, data *common.Metatiles) *fastjson.
 		result.Set(palette, paletteObj)
 	}
 
+	if len(data.CGBPalettes) != 0 {
+		result.Set(cgbPalettes, serializeCGBPalettes(arena, data.CGBPalettes))
+	}
+
+	return result
+}
+
+func serializeCGBPalettes(arena *fastjson.Arena, palettes [][]color.Color) *fastjson.Value {
+	palettesArr := arena.NewArray()
+	for i, plt := range palettes {
+		pltArr := arena.NewArray()
+		for j := range plt {
+			pltArr.SetArrayItem(j, serializeRGB555(arena, plt[j]))
+		}
+		palettesArr.SetArrayItem(i, pltArr)
+	}
+	return palettesArr
+}
+
+func serializeMetatile(arena *fastjson.Arena, size common.MetatileSize, mtile common.Metatile) *fastjson.Value {
+	result := arena.NewObject()
+	if size.IsDefault() && len(mtile.Tiles) == size.TileCount() {
+		for i, key := range []string{topLeft, topRight, bottomLeft, bottomRight} {
+			result.Set(key, arena.NewString(fmt.Sprintf("%x", mtile.Tiles[i])))
+		}
+	} else {
+		tilesArr := arena.NewArray()
+		for i, index := range mtile.Tiles {
+			tilesArr.SetArrayItem(i, arena.NewString(fmt.Sprintf("%x", index)))
+		}
+		result.Set(tiles, tilesArr)
+	}
+
+	if len(mtile.Attributes) != 0 {
+		attrArr := arena.NewArray()
+		for i, attr := range mtile.Attributes {
+			attrArr.SetArrayItem(i, arena.NewString(fmt.Sprintf("%x", uint8(attr))))
+		}
+		result.Set(attributes, attrArr)
+	}
+
+	if len(mtile.Properties) != 0 {
+		props := arena.NewObject()
+		for _, prop := range mtile.Properties {
+			props.Set(prop.Name, serializeProperty(arena, prop))
+		}
+		result.Set(properties, props)
+	}
+
 	return result
 }
 
-func serializeMetatile(arena *fastjson.Arena, mtile common.Metatile) *fastjson.Value {
+// Numbers and booleans are written as JSON values of the same type
+func serializeProperty(arena *fastjson.Arena, prop common.Property) *fastjson.Value {
+	switch prop.Type {
+	case common.PropertyInt, common.PropertyFloat:
+		return arena.NewNumberString(prop.Value)
+	case common.PropertyBool:
+		if prop.Value == "true" {
+			return arena.NewTrue()
+		}
+		return arena.NewFalse()
+	default:
+		return arena.NewString(prop.Value)
+	}
+}
+
+// SerializeMapData writes cells and attributes inline, source files of the map are not referenced
+func SerializeMapData(tileMap *common.TileMap) *fastjson.Value {
+	arena := &fastjson.Arena{}
 	result := arena.NewObject()
-	result.Set(topLeft, arena.NewString(fmt.Sprintf("%x", mtile.TopLeft)))
-	result.Set(topRight, arena.NewString(fmt.Sprintf("%x", mtile.TopRight)))
-	result.Set(bottomLeft, arena.NewString(fmt.Sprintf("%x", mtile.BottomLeft)))
-	result.Set(bottomRight, arena.NewString(fmt.Sprintf("%x", mtile.BottomRight)))
+	result.Set(fileType, arena.NewString(typeMapData))
+	result.Set(width, arena.NewNumberInt(tileMap.Width))
+	result.Set(height, arena.NewNumberInt(tileMap.Height))
+
+	if len(tileMap.MetatileFile) != 0 {
+		result.Set(mtiles, arena.NewString(tileMap.MetatileFile))
+	}
+	if tileMap.Refs.Size() != 0 {
+		tileRefs := arena.NewObject()
+		for it := tileMap.Refs.Begin(); it != nil; it = it.Next() {
+			tileRefs.Set(serializeTileRef(arena, it.GetValue()))
+		}
+		result.Set(tiles, tileRefs)
+	}
+	if tileMap.Addressing == common.Addressing8800 {
+		result.Set(addressing, arena.NewString(addressing8800))
+	}
+	if len(tileMap.Palette) != 0 {
+		paletteObj := arena.NewArray()
+		for i := range tileMap.Palette {
+			paletteObj.SetArrayItem(i, serializeColor(tileMap.Palette, arena, tileMap.Palette[i]))
+		}
+		result.Set(palette, paletteObj)
+	}
+	if len(tileMap.CGBPalettes) != 0 {
+		result.Set(cgbPalettes, serializeCGBPalettes(arena, tileMap.CGBPalettes))
+	}
+
+	cellArr := arena.NewArray()
+	for i, cell := range tileMap.Cells {
+		cellArr.SetArrayItem(i, arena.NewString(fmt.Sprintf("%x", cell)))
+	}
+	result.Set(cells, cellArr)
+	if len(tileMap.Attributes) != 0 {
+		attrArr := arena.NewArray()
+		for i, attr := range tileMap.Attributes {
+			attrArr.SetArrayItem(i, arena.NewString(fmt.Sprintf("%x", uint8(attr))))
+		}
+		result.Set(attributes, attrArr)
+	}
 
 	return result
 }
@@ -84,8 +194,15 @@ func serializeTileRange(rng common.IndexRange) string {
 	}
 }
 
+func serializeBankRange(rng common.BankRange) string {
+	if rng.Bank != 0 {
+		return fmt.Sprintf("%s%s%d", serializeTileRange(rng.Range), bankSeparator, rng.Bank)
+	}
+	return serializeTileRange(rng.Range)
+}
+
 func serializeTileRef(arena *fastjson.Arena, ref common.TileRef) (key string, refStr *fastjson.Value) {
-	key = serializeTileRange(ref.Range)
+	key = serializeBankRange(common.BankRange{Range: ref.Range, Bank: ref.Bank})
 
 	if ref.Offset != 0 {
 		refStr = arena.NewString(fmt.Sprintf("%s:%x", ref.File, ref.Offset))
@@ -96,6 +213,11 @@ func serializeTileRef(arena *fastjson.Arena, ref common.TileRef) (key string, re
 	return key, refStr
 }
 
+func serializeRGB555(arena *fastjson.Arena, c color.Color) *fastjson.Value {
+	r, g, b, _ := c.RGBA()
+	return arena.NewString(fmt.Sprintf("%04x", r>>11|g>>11<<5|b>>11<<10))
+}
+
 func serializeColor(palette []color.Color, arena *fastjson.Arena, c color.Color) *fastjson.Value {
 	model := color.Palette(palette)
 	r, g, b, _ := model.Convert(c).RGBA()
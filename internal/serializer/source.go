@@ -0,0 +1,111 @@
+package serializer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	bytesPerLine = 16
+	generatedBy  = "generated by tileset_manager"
+)
+
+// Convert file name to C/assembly identifier
+func Identifier(name string) string {
+	result := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+	if len(result) == 0 || unicode.IsDigit(rune(result[0])) {
+		result = "_" + result
+	}
+	return result
+}
+
+// Symbol exported to RGBDS assembly or C
+type SourceArray struct {
+	// Symbol name suffix, e.g. "tiles" for <name>_tiles
+	Suffix string
+	Data   []byte
+	// Bytes per line, 0 means default
+	LineLength int
+	// Number of elements for the count constant, omitted if empty CountName
+	CountName string
+	Count     int
+}
+
+// Write arrays as RGBDS assembly
+// name: base name of the symbols
+// section: ROM section type, e.g. ROMX
+func ToASM(name, section string, arrays ...SourceArray) []byte {
+	id := Identifier(name)
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "; %s\n\n", generatedBy)
+
+	for _, arr := range arrays {
+		if len(arr.CountName) != 0 {
+			fmt.Fprintf(builder, "DEF %s_%s EQU %d\n", strings.ToUpper(id), arr.CountName, arr.Count)
+		}
+	}
+
+	for _, arr := range arrays {
+		label := id + "_" + arr.Suffix
+		fmt.Fprintf(builder, "\nSECTION \"%s\", %s\n\n%s::\n", label, section, label)
+		writeLines(builder, arr, "\tdb ", "$%02x", "\n")
+		fmt.Fprintf(builder, ".end::\n")
+	}
+
+	return []byte(builder.String())
+}
+
+// Write arrays as GBDK-style C header and source
+// fileName: name of the header file without extension
+// name: base name of the symbols
+func ToC(fileName, name string, arrays ...SourceArray) (header []byte, source []byte) {
+	id := Identifier(name)
+	guard := strings.ToUpper(Identifier(fileName)) + "_H"
+
+	h := &strings.Builder{}
+	fmt.Fprintf(h, "// %s\n\n#ifndef %s\n#define %s\n\n", generatedBy, guard, guard)
+	for _, arr := range arrays {
+		if len(arr.CountName) != 0 {
+			fmt.Fprintf(h, "#define %s_%s %d\n", strings.ToUpper(id), arr.CountName, arr.Count)
+		}
+	}
+	for _, arr := range arrays {
+		fmt.Fprintf(h, "extern const unsigned char %s_%s[%d];\n", id, arr.Suffix, len(arr.Data))
+	}
+	fmt.Fprintf(h, "\n#endif\n")
+
+	c := &strings.Builder{}
+	fmt.Fprintf(c, "// %s\n\n#include \"%s.h\"\n", generatedBy, fileName)
+	for _, arr := range arrays {
+		fmt.Fprintf(c, "\nconst unsigned char %s_%s[%d] = {\n", id, arr.Suffix, len(arr.Data))
+		writeLines(c, arr, "\t", "0x%02x", ",\n")
+		fmt.Fprintf(c, "};\n")
+	}
+
+	return []byte(h.String()), []byte(c.String())
+}
+
+func writeLines(builder *strings.Builder, arr SourceArray, prefix, format, lineEnd string) {
+	lineLength := arr.LineLength
+	if lineLength <= 0 {
+		lineLength = bytesPerLine
+	}
+
+	for start := 0; start < len(arr.Data); start += lineLength {
+		end := start + lineLength
+		if end > len(arr.Data) {
+			end = len(arr.Data)
+		}
+		values := make([]string, 0, end-start)
+		for _, b := range arr.Data[start:end] {
+			values = append(values, fmt.Sprintf(format, b))
+		}
+		builder.WriteString(prefix + strings.Join(values, ", ") + lineEnd)
+	}
+}
@@ -0,0 +1,80 @@
+package serializer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentifier(t *testing.T) {
+	assert.Equal(t, "level_1", Identifier("level-1"))
+	assert.Equal(t, "_1up", Identifier("1up"))
+	assert.Equal(t, "_", Identifier(""))
+	assert.Equal(t, "caf_", Identifier("café"))
+}
+
+var testArrays = []SourceArray{
+	{Suffix: "tiles", Data: []byte{0, 1, 2, 0xff, 0x10}, LineLength: 2, CountName: "TILE_COUNT", Count: 3},
+	{Suffix: "attrs", Data: []byte{0x20}},
+}
+
+const expectedASM = `; generated by tileset_manager
+
+DEF _1LEVEL_TILE_COUNT EQU 3
+
+SECTION "_1level_tiles", ROMX
+
+_1level_tiles::
+	db $00, $01
+	db $02, $ff
+	db $10
+.end::
+
+SECTION "_1level_attrs", ROMX
+
+_1level_attrs::
+	db $20
+.end::
+`
+
+const expectedHeader = `// generated by tileset_manager
+
+#ifndef _1LEVEL_TILE_H
+#define _1LEVEL_TILE_H
+
+#define _1LEVEL_TILE_COUNT 3
+extern const unsigned char _1level_tiles[5];
+extern const unsigned char _1level_attrs[1];
+
+#endif
+`
+
+const expectedSource = `// generated by tileset_manager
+
+#include "1level.tile.h"
+
+const unsigned char _1level_tiles[5] = {
+	0x00, 0x01,
+	0x02, 0xff,
+	0x10,
+};
+
+const unsigned char _1level_attrs[1] = {
+	0x20,
+};
+`
+
+func TestToASM(t *testing.T) {
+	assert.Equal(t, expectedASM, string(ToASM("1level", "ROMX", testArrays...)))
+}
+
+func TestToC(t *testing.T) {
+	header, source := ToC("1level.tile", "1level", testArrays...)
+	assert.Equal(t, expectedHeader, string(header))
+	assert.Equal(t, expectedSource, string(source))
+}
+
+func TestDefaultLineLength(t *testing.T) {
+	source := ToASM("a", "ROM0", SourceArray{Suffix: "data", Data: make([]byte, bytesPerLine+1)})
+	assert.Contains(t, string(source), "\tdb $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00\n\tdb $00\n")
+}
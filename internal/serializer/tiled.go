@@ -0,0 +1,320 @@
+package serializer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+)
+
+const tiledVersion = "1.10"
+
+// Flags stored in the high bits of global tile ids of Tiled maps
+const (
+	TiledFlipX        uint32 = 1 << 31
+	TiledFlipY        uint32 = 1 << 30
+	TiledFlipDiagonal uint32 = 1 << 29
+	TiledGIDMask      uint32 = 1<<28 - 1
+)
+
+var tiledPropertyTypes = map[common.PropertyType]string{
+	common.PropertyInt:   "int",
+	common.PropertyFloat: "float",
+	common.PropertyBool:  "bool",
+}
+
+// Tiled tileset of a single image, read from and written to TSX files
+type TiledTileset struct {
+	Name                  string
+	TileWidth, TileHeight int
+	TileCount, Columns    int
+	// Image path relative to the TSX file
+	Image                   string
+	ImageWidth, ImageHeight int
+	Properties              []common.Property
+	// Properties of the tiles by tile id, may be shorter than TileCount
+	TileProperties [][]common.Property
+}
+
+// Tile layer of a Tiled map
+type TiledMap struct {
+	Width, Height int
+	Tilesets      []TiledTilesetRef
+	// Global tile ids of the cells including the flip flags, row by row. 0 is an empty cell
+	Cells []uint32
+}
+
+type TiledTilesetRef struct {
+	FirstGID uint32
+	// Path of the TSX file relative to the map, empty for embedded tilesets
+	Source string
+	// Only set for embedded tilesets
+	Tileset *TiledTileset
+}
+
+type xmlTileset struct {
+	XMLName    xml.Name      `xml:"tileset"`
+	Version    string        `xml:"version,attr,omitempty"`
+	FirstGID   uint32        `xml:"firstgid,attr,omitempty"`
+	Source     string        `xml:"source,attr,omitempty"`
+	Name       string        `xml:"name,attr,omitempty"`
+	TileWidth  int           `xml:"tilewidth,attr,omitempty"`
+	TileHeight int           `xml:"tileheight,attr,omitempty"`
+	TileCount  int           `xml:"tilecount,attr,omitempty"`
+	Columns    int           `xml:"columns,attr,omitempty"`
+	Properties []xmlProperty `xml:"properties>property"`
+	Image      *xmlImage     `xml:"image"`
+	Tiles      []xmlTile     `xml:"tile"`
+}
+
+type xmlProperty struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:"value,attr"`
+	// Multiline strings are stored as text instead of the value
+	Text string `xml:",chardata"`
+}
+
+type xmlImage struct {
+	Source string `xml:"source,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+}
+
+type xmlTile struct {
+	ID         int           `xml:"id,attr"`
+	Properties []xmlProperty `xml:"properties>property"`
+}
+
+type xmlMap struct {
+	Width    int          `xml:"width,attr"`
+	Height   int          `xml:"height,attr"`
+	Infinite int          `xml:"infinite,attr"`
+	Tilesets []xmlTileset `xml:"tileset"`
+	Layers   []xmlLayer   `xml:"layer"`
+}
+
+type xmlLayer struct {
+	Name   string  `xml:"name,attr"`
+	Width  int     `xml:"width,attr"`
+	Height int     `xml:"height,attr"`
+	Data   xmlData `xml:"data"`
+}
+
+type xmlData struct {
+	Encoding    string `xml:"encoding,attr"`
+	Compression string `xml:"compression,attr"`
+	Text        string `xml:",chardata"`
+	Tiles       []struct {
+		GID uint32 `xml:"gid,attr"`
+	} `xml:"tile"`
+	Chunks []struct{} `xml:"chunk"`
+}
+
+// SerializeTSX writes the tileset as a TSX file, tiles without properties are omitted
+func SerializeTSX(tileset *TiledTileset) []byte {
+	result := xmlTileset{
+		Version:    tiledVersion,
+		Name:       tileset.Name,
+		TileWidth:  tileset.TileWidth,
+		TileHeight: tileset.TileHeight,
+		TileCount:  tileset.TileCount,
+		Columns:    tileset.Columns,
+		Properties: serializeTiledProperties(tileset.Properties),
+		Image: &xmlImage{
+			Source: tileset.Image,
+			Width:  tileset.ImageWidth,
+			Height: tileset.ImageHeight,
+		},
+	}
+	for id, props := range tileset.TileProperties {
+		if len(props) != 0 {
+			result.Tiles = append(result.Tiles, xmlTile{ID: id, Properties: serializeTiledProperties(props)})
+		}
+	}
+
+	data, _ := xml.MarshalIndent(result, "", " ")
+	return append(append([]byte(xml.Header), data...), '\n')
+}
+
+func serializeTiledProperties(props []common.Property) []xmlProperty {
+	result := make([]xmlProperty, 0, len(props))
+	for _, prop := range props {
+		result = append(result, xmlProperty{Name: prop.Name, Type: tiledPropertyTypes[prop.Type], Value: prop.Value})
+	}
+	return result
+}
+
+// ParseTSX parses contents of a TSX file, path is only used in error messages
+func ParseTSX(path string, data []byte) (*TiledTileset, error) {
+	var parsed xmlTileset
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return nil, common.Wrap(err, "could not parse tileset", path)
+	}
+	return parseTiledTileset(path, &parsed)
+}
+
+func parseTiledTileset(path string, parsed *xmlTileset) (*TiledTileset, error) {
+	if parsed.Image == nil {
+		return nil, fmt.Errorf("tilesets without an image are not supported: %s", path)
+	}
+	result := &TiledTileset{
+		Name:        parsed.Name,
+		TileWidth:   parsed.TileWidth,
+		TileHeight:  parsed.TileHeight,
+		TileCount:   parsed.TileCount,
+		Columns:     parsed.Columns,
+		Image:       parsed.Image.Source,
+		ImageWidth:  parsed.Image.Width,
+		ImageHeight: parsed.Image.Height,
+		Properties:  parseTiledProperties(parsed.Properties),
+	}
+	for _, tile := range parsed.Tiles {
+		if tile.ID < 0 || tile.ID >= parsed.TileCount {
+			return nil, fmt.Errorf("tile id %d out of range: %s", tile.ID, path)
+		}
+		for len(result.TileProperties) <= tile.ID {
+			result.TileProperties = append(result.TileProperties, nil)
+		}
+		result.TileProperties[tile.ID] = parseTiledProperties(tile.Properties)
+	}
+	return result, nil
+}
+
+// Properties of types other than int, float and bool, e.g. colors and files, are read as strings
+func parseTiledProperties(props []xmlProperty) []common.Property {
+	var result []common.Property
+	for _, prop := range props {
+		parsed := common.Property{Name: prop.Name, Value: prop.Value}
+		for propType, name := range tiledPropertyTypes {
+			if prop.Type == name {
+				parsed.Type = propType
+			}
+		}
+		if len(parsed.Value) == 0 && parsed.Type == common.PropertyString {
+			parsed.Value = prop.Text
+		}
+		result = append(result, parsed)
+	}
+	return result
+}
+
+// ParseTMX parses a tile layer of a TMX file, the first one if layer is empty. Path is only used in error messages
+func ParseTMX(path string, data []byte, layer string) (*TiledMap, error) {
+	var parsed xmlMap
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return nil, common.Wrap(err, "could not parse map", path)
+	}
+	if parsed.Infinite != 0 {
+		return nil, fmt.Errorf("infinite maps are not supported: %s", path)
+	}
+
+	var found *xmlLayer
+	for i := range parsed.Layers {
+		if len(layer) == 0 || parsed.Layers[i].Name == layer {
+			found = &parsed.Layers[i]
+			break
+		}
+	}
+	if found == nil && len(layer) == 0 {
+		return nil, fmt.Errorf("map has no tile layers: %s", path)
+	} else if found == nil {
+		return nil, fmt.Errorf("layer %q not found: %s", layer, path)
+	}
+
+	result := &TiledMap{Width: found.Width, Height: found.Height}
+	var err error
+	result.Cells, err = decodeTiledLayer(&found.Data, found.Width*found.Height)
+	if err != nil {
+		return nil, common.Wrap(err, fmt.Sprintf("invalid layer %q", found.Name), path)
+	}
+
+	for i := range parsed.Tilesets {
+		ref := TiledTilesetRef{FirstGID: parsed.Tilesets[i].FirstGID, Source: parsed.Tilesets[i].Source}
+		if len(ref.Source) == 0 {
+			ref.Tileset, err = parseTiledTileset(path, &parsed.Tilesets[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		result.Tilesets = append(result.Tilesets, ref)
+	}
+	return result, nil
+}
+
+// decodeTiledLayer supports XML, CSV and base64 layer data, base64 data may be compressed with zlib or gzip
+func decodeTiledLayer(data *xmlData, count int) ([]uint32, error) {
+	if len(data.Chunks) != 0 {
+		return nil, errors.New("chunked layer data is not supported")
+	}
+
+	var cells []uint32
+	switch data.Encoding {
+	case "":
+		for _, tile := range data.Tiles {
+			cells = append(cells, tile.GID)
+		}
+	case "csv":
+		for _, str := range strings.Split(strings.TrimSpace(data.Text), ",") {
+			gid, err := strconv.ParseUint(strings.TrimSpace(str), 10, 32)
+			if err != nil {
+				return nil, common.Wrap(err, "invalid csv data")
+			}
+			cells = append(cells, uint32(gid))
+		}
+	case "base64":
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data.Text))
+		if err != nil {
+			return nil, common.Wrap(err, "invalid base64 data")
+		}
+		raw, err = decompressTiledLayer(raw, data.Compression)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw)%4 != 0 {
+			return nil, fmt.Errorf("data size %d is not a multiple of 4", len(raw))
+		}
+		for i := 0; i < len(raw); i += 4 {
+			cells = append(cells, binary.LittleEndian.Uint32(raw[i:]))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", data.Encoding)
+	}
+
+	if len(cells) != count {
+		return nil, fmt.Errorf("expected %d cells, got %d", count, len(cells))
+	}
+	return cells, nil
+}
+
+func decompressTiledLayer(data []byte, compression string) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch compression {
+	case "":
+		return data, nil
+	case "zlib":
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+	if err == nil {
+		data, err = io.ReadAll(r)
+		r.Close()
+	}
+	if err != nil {
+		return nil, common.Wrap(err, "could not decompress "+compression+" data")
+	}
+	return data, nil
+}
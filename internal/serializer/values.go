@@ -0,0 +1,117 @@
+package serializer
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/valyala/fastjson"
+)
+
+// Parsers for single values passed on the command line. Lists are comma-separated
+
+// ParsePalette parses a list of colors, e.g. "ffffff,aaaaaa,555555,000000"
+func ParsePalette(colors string) ([]color.Color, error) {
+	result := []color.Color{}
+	for _, str := range strings.Split(colors, ",") {
+		c, err := parseColor(str)
+		if err != nil {
+			return nil, common.Wrap(err, fmt.Sprintf("invalid color %q", str))
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// ParseOutputType parses a list of output types, e.g. "png,asm"
+func ParseOutputType(types string) (common.OutputType, error) {
+	var result common.OutputType
+	for _, str := range strings.Split(types, ",") {
+		flags, err := lookup(outputTypes, "output type", str)
+		if err != nil {
+			return 0, err
+		}
+		result |= flags
+	}
+	return result, nil
+}
+
+func ParseLayout(str string) (common.MetatileLayout, error) {
+	return lookup(layouts, "layout", str)
+}
+
+func ParseAddressing(str string) (common.AddressingMode, error) {
+	return lookup(addressingModes, "addressing mode", str)
+}
+
+func ParseTileFormat(str string) (common.TileFormat, error) {
+	return lookup(tileFormats, "tile format", str)
+}
+
+func ParseCompression(str string) (common.Compression, error) {
+	return lookup(compressions, "compression", str)
+}
+
+// ParseBitColors parses color indexes of the 0 and 1 bits of 1bpp tiles, e.g. "0,3"
+func ParseBitColors(str string) (common.BitColors, error) {
+	var result common.BitColors
+	indexes := strings.Split(str, ",")
+	if len(indexes) != len(result) {
+		return result, fmt.Errorf("expected 2 color indexes, got %d", len(indexes))
+	}
+	for i, index := range indexes {
+		value, err := strconv.ParseUint(index, 10, 8)
+		if err != nil {
+			return result, fmt.Errorf("invalid color index %q", index)
+		}
+		result[i] = uint8(value)
+	}
+	if result[0] == result[1] {
+		return result, errors.New("colors of 0 and 1 bits must differ")
+	}
+	return result, nil
+}
+
+func ParseCompileType(str string) (common.CompileType, error) {
+	return lookup(compileTypes, "compile type", str)
+}
+
+// ParseDedup reports whether flipped tiles should be merged
+func ParseDedup(str string) (bool, error) {
+	return lookup(dedupModes, "deduplication mode", str)
+}
+
+func lookup[T any](values map[string]T, kind, str string) (T, error) {
+	result, ok := values[str]
+	if !ok {
+		allowed := make([]string, 0, len(values))
+		for key := range values {
+			allowed = append(allowed, key)
+		}
+		sort.Strings(allowed)
+		return result, fmt.Errorf("unknown %s %q, expected one of: %s", kind, str, strings.Join(allowed, ", "))
+	}
+	return result, nil
+}
+
+// ParseData parses contents of a .tile.json, .mtile.json or .map.json file depending on its type,
+// files without type are parsed as configs.
+// The result is *common.Tiles, *common.Metatiles, *common.TileMap or *common.Config
+func ParseData(path string, data []byte, strict bool) (any, error) {
+	switch ftype := fastjson.GetString(data, fileType); ftype {
+	case "":
+		return ParseConfigBytes(path, data)
+	case typeTileData:
+		return ParseTileDataBytes(path, data, strict)
+	case typeMetatileData:
+		return ParseMetatileDataBytes(path, data, strict)
+	case typeMapData:
+		return ParseMapDataBytes(path, data, strict)
+	default:
+		return nil, fmt.Errorf("unknown file type %q: %s", ftype, path)
+	}
+}
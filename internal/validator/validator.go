@@ -0,0 +1,348 @@
+// Package validator checks JSON documents against the subset of JSON Schema used by the schemas in the repository
+package validator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/schemas"
+	"github.com/valyala/fastjson"
+)
+
+// Error describes a single schema violation, Pointer is a JSON pointer to the offending value
+type Error struct {
+	Pointer string
+	Reason  string
+}
+
+// Validator holds parsed schemas referenced by their file names. It is safe for concurrent use
+type Validator struct {
+	schemas  map[string]*fastjson.Value
+	patterns map[string]*regexp.Regexp
+}
+
+// New loads all .json files from the root of fsys as schemas
+func New(fsys fs.FS) (*Validator, error) {
+	files, err := fs.Glob(fsys, "*"+common.ExtensionJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Validator{
+		schemas:  make(map[string]*fastjson.Value, len(files)),
+		patterns: map[string]*regexp.Regexp{},
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, common.Wrap(err, "could not read schema", file)
+		}
+		schema, err := fastjson.ParseBytes(data)
+		if err != nil {
+			return nil, common.Wrap(err, "could not parse schema", file)
+		}
+		err = v.compilePatterns(schema)
+		if err != nil {
+			return nil, common.Wrap(err, "invalid schema", file)
+		}
+		v.schemas[path.Base(file)] = schema
+	}
+	return v, nil
+}
+
+var (
+	embedded     *Validator
+	embeddedErr  error
+	embeddedOnce sync.Once
+)
+
+// Embedded returns the validator for schemas embedded into the binary
+func Embedded() (*Validator, error) {
+	embeddedOnce.Do(func() {
+		embedded, embeddedErr = New(schemas.FS)
+	})
+	return embedded, embeddedErr
+}
+
+// Validate checks value against the schema with the given file name
+func (v *Validator) Validate(schema string, value *fastjson.Value) []Error {
+	root, ok := v.schemas[schema]
+	if !ok {
+		return []Error{{Reason: fmt.Sprintf("unknown schema %s", schema)}}
+	}
+
+	var errs []Error
+	v.validate(schema, root, value, "", &errs)
+	return errs
+}
+
+// compilePatterns walks the whole schema, which also makes fastjson unescape all keys and strings
+// so that the schema is only read afterwards
+func (v *Validator) compilePatterns(schema *fastjson.Value) error {
+	switch schema.Type() {
+	case fastjson.TypeObject:
+		var err error
+		schema.GetObject().Visit(func(key []byte, value *fastjson.Value) {
+			if err != nil {
+				return
+			}
+			if string(key) == "pattern" && value.Type() == fastjson.TypeString {
+				pattern := string(value.GetStringBytes())
+				v.patterns[pattern], err = regexp.Compile(pattern)
+				return
+			}
+			err = v.compilePatterns(value)
+		})
+		return err
+	case fastjson.TypeArray:
+		for _, item := range schema.GetArray() {
+			if err := v.compilePatterns(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve finds the schema referenced by ref. Refs are either local (#/definitions/name)
+// or point to another schema file (util.json#/definitions/name)
+func (v *Validator) resolve(file, ref string) (string, *fastjson.Value) {
+	refFile, fragment, _ := strings.Cut(ref, "#")
+	if len(refFile) != 0 {
+		file = path.Base(refFile)
+	}
+	schema, ok := v.schemas[file]
+	if !ok {
+		return file, nil
+	}
+
+	fragment = strings.TrimPrefix(fragment, "/")
+	if len(fragment) == 0 {
+		return file, schema
+	}
+	keys := strings.Split(fragment, "/")
+	for i := range keys {
+		keys[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(keys[i])
+	}
+	return file, schema.Get(keys...)
+}
+
+func (v *Validator) validate(file string, schema, value *fastjson.Value, ptr string, errs *[]Error) {
+	report := func(format string, args ...any) {
+		*errs = append(*errs, Error{Pointer: ptr, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	switch schema.Type() {
+	case fastjson.TypeFalse:
+		report("value is not allowed")
+		return
+	case fastjson.TypeObject:
+	default:
+		return
+	}
+
+	if ref := schema.GetStringBytes("$ref"); ref != nil {
+		refFile, refSchema := v.resolve(file, string(ref))
+		if refSchema == nil {
+			report("could not resolve schema %s", ref)
+		} else {
+			v.validate(refFile, refSchema, value, ptr, errs)
+		}
+	}
+
+	if types := schema.Get("type"); types != nil && !matchesType(value, types) {
+		report("expected %s, got %s", strings.Trim(types.String(), "[]"), typeName(value))
+		return
+	}
+
+	if enum := schema.GetArray("enum"); enum != nil {
+		allowed := make([]string, 0, len(enum))
+		found := false
+		for i := range enum {
+			allowed = append(allowed, enum[i].String())
+			found = found || enum[i].String() == value.String()
+		}
+		if !found {
+			report("%s is not one of %s", value.String(), strings.Join(allowed, ", "))
+		}
+	}
+
+	switch value.Type() {
+	case fastjson.TypeString:
+		str := string(value.GetStringBytes())
+		if pattern := schema.GetStringBytes("pattern"); pattern != nil && !v.patterns[string(pattern)].MatchString(str) {
+			report("%q does not match pattern %s", str, pattern)
+		}
+		if minLength := schema.Get("minLength"); minLength != nil && len(str) < minLength.GetInt() {
+			report("expected at least %d characters", minLength.GetInt())
+		}
+		if maxLength := schema.Get("maxLength"); maxLength != nil && len(str) > maxLength.GetInt() {
+			report("expected at most %d characters", maxLength.GetInt())
+		}
+		if string(schema.GetStringBytes("contentEncoding")) == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+				report("invalid base64: %s", err.Error())
+			}
+		}
+	case fastjson.TypeNumber:
+		number := value.GetFloat64()
+		if minimum := schema.Get("minimum"); minimum != nil && number < minimum.GetFloat64() {
+			report("%s is less than %s", value.String(), minimum.String())
+		}
+		if maximum := schema.Get("maximum"); maximum != nil && number > maximum.GetFloat64() {
+			report("%s is greater than %s", value.String(), maximum.String())
+		}
+	case fastjson.TypeArray:
+		arr := value.GetArray()
+		if minItems := schema.Get("minItems"); minItems != nil && len(arr) < minItems.GetInt() {
+			report("expected at least %d items, got %d", minItems.GetInt(), len(arr))
+		}
+		if maxItems := schema.Get("maxItems"); maxItems != nil && len(arr) > maxItems.GetInt() {
+			report("expected at most %d items, got %d", maxItems.GetInt(), len(arr))
+		}
+		if items := schema.Get("items"); items != nil {
+			for i := range arr {
+				v.validate(file, items, arr[i], ptr+"/"+strconv.Itoa(i), errs)
+			}
+		}
+	case fastjson.TypeObject:
+		v.validateObject(file, schema, value.GetObject(), ptr, errs)
+	}
+
+	for _, sub := range schema.GetArray("allOf") {
+		v.validate(file, sub, value, ptr, errs)
+	}
+	if anyOf := schema.GetArray("anyOf"); anyOf != nil {
+		if matched, closest := v.countMatches(file, anyOf, value, ptr); matched == 0 {
+			*errs = append(*errs, closest...)
+		}
+	}
+	if oneOf := schema.GetArray("oneOf"); oneOf != nil {
+		matched, closest := v.countMatches(file, oneOf, value, ptr)
+		switch {
+		case matched == 0:
+			*errs = append(*errs, closest...)
+		case matched > 1:
+			report("value matches %d schemas, expected exactly one", matched)
+		}
+	}
+	if not := schema.Get("not"); not != nil {
+		var notErrs []Error
+		v.validate(file, not, value, ptr, &notErrs)
+		if len(notErrs) == 0 {
+			report("value matches a disallowed schema")
+		}
+	}
+}
+
+func (v *Validator) validateObject(file string, schema *fastjson.Value, obj *fastjson.Object, ptr string, errs *[]Error) {
+	report := func(format string, args ...any) {
+		*errs = append(*errs, Error{Pointer: ptr, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if minProperties := schema.Get("minProperties"); minProperties != nil && obj.Len() < minProperties.GetInt() {
+		report("expected at least %d properties, got %d", minProperties.GetInt(), obj.Len())
+	}
+	if maxProperties := schema.Get("maxProperties"); maxProperties != nil && obj.Len() > maxProperties.GetInt() {
+		report("expected at most %d properties, got %d", maxProperties.GetInt(), obj.Len())
+	}
+	for _, required := range schema.GetArray("required") {
+		if key := string(required.GetStringBytes()); obj.Get(key) == nil {
+			report("missing required property %q", key)
+		}
+	}
+
+	properties := schema.GetObject("properties")
+	additional := schema.Get("additionalProperties")
+	propertyNames := schema.Get("propertyNames")
+	obj.Visit(func(key []byte, value *fastjson.Value) {
+		valuePtr := ptr + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(string(key))
+		if propertyNames != nil {
+			var arena fastjson.Arena
+			v.validate(file, propertyNames, arena.NewStringBytes(key), valuePtr, errs)
+		}
+
+		var property *fastjson.Value
+		if properties != nil {
+			property = properties.Get(string(key))
+		}
+		if property != nil {
+			v.validate(file, property, value, valuePtr, errs)
+		} else if additional != nil {
+			v.validate(file, additional, value, valuePtr, errs)
+		}
+	})
+}
+
+// countMatches returns the number of schemas value matches and errors of the closest schema.
+// The closest schema is the one with the least errors about the value itself rather than its contents
+func (v *Validator) countMatches(file string, schemas []*fastjson.Value, value *fastjson.Value, ptr string) (int, []Error) {
+	matched := 0
+	var closest []Error
+	closestTopLevel := 0
+	for i := range schemas {
+		var errs []Error
+		v.validate(file, schemas[i], value, ptr, &errs)
+		if len(errs) == 0 {
+			matched++
+			continue
+		}
+
+		topLevel := 0
+		for j := range errs {
+			if errs[j].Pointer == ptr {
+				topLevel++
+			}
+		}
+		if closest == nil || topLevel < closestTopLevel || (topLevel == closestTopLevel && len(errs) < len(closest)) {
+			closest, closestTopLevel = errs, topLevel
+		}
+	}
+	return matched, closest
+}
+
+func matchesType(value *fastjson.Value, types *fastjson.Value) bool {
+	names := []*fastjson.Value{types}
+	if arr, err := types.Array(); err == nil {
+		names = arr
+	}
+
+	actual := typeName(value)
+	for i := range names {
+		switch expected := string(names[i].GetStringBytes()); {
+		case expected == actual:
+			return true
+		case expected == "integer" && actual == "number":
+			number := value.GetFloat64()
+			if number == math.Trunc(number) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeName(value *fastjson.Value) string {
+	switch value.Type() {
+	case fastjson.TypeObject:
+		return "object"
+	case fastjson.TypeArray:
+		return "array"
+	case fastjson.TypeString:
+		return "string"
+	case fastjson.TypeNumber:
+		return "number"
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
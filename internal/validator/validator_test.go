@@ -0,0 +1,55 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/Onlymiind/tileset_manager/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fastjson"
+)
+
+func validate(t *testing.T, schema, json string) []string {
+	v, err := Embedded()
+	assert.NoError(t, err)
+
+	pointers := []string{}
+	for _, e := range v.Validate(schema, fastjson.MustParse(json)) {
+		pointers = append(pointers, e.Pointer)
+	}
+	return pointers
+}
+
+func TestValidMetatiles(t *testing.T) {
+	assert.Empty(t, validate(t, schemas.Metatiles, `{
+		"type": "mtiles",
+		"palette": ["ffffff", "aaaaaa", "555555", "000000"],
+		"tiles": {"0:7f": "tiles.chr", "80@1": "tiles.chr:2"},
+		"metatiles": [
+			{"tl": "0", "tr": "1", "bl": "2", "br": "3", "attributes": ["0", "20", "40", "60"]},
+			{"tiles": ["0", "1"]}
+		],
+		"absent_tiles": ["5", "8:a"]
+	}`))
+}
+
+func TestInvalidMetatiles(t *testing.T) {
+	assert.ElementsMatch(t, []string{"/tiles/0:7f", "/tiles/g", "/metatiles/0", "/metatiles/1/tl", "/absent_tiles/0"}, validate(t, schemas.Metatiles, `{
+		"type": "mtiles",
+		"tiles": {"0:7f": "tiles.txt", "g": "tiles.chr"},
+		"metatiles": [
+			{"tl": "0", "tr": "1"},
+			{"tiles": ["0"], "tl": "100"}
+		],
+		"absent_tiles": ["5:"]
+	}`))
+}
+
+func TestConfig(t *testing.T) {
+	assert.Empty(t, validate(t, schemas.Config, `{"output": {"type": ["asm", "2bpp"]}, "cache_size": 10, "empty_tile": {"0": "empty.chr"}}`))
+	assert.ElementsMatch(t, []string{"/output/type/1", "/cache_size", "/empty_tile", "/compile/0"}, validate(t, schemas.Config, `{
+		"output": {"type": ["asm", "bin"]},
+		"cache_size": 1.5,
+		"empty_tile": {"0": "empty.chr", "1": "empty.chr"},
+		"compile": [{"name": "level"}]
+	}`))
+}
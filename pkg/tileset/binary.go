@@ -0,0 +1,103 @@
+package tileset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Onlymiind/tileset_manager/internal/extractor"
+)
+
+// DecodeTiles reads binary tile data of the format, incomplete trailing tiles are ignored.
+// Color indexes of 1bpp tiles are 0 and 1, see ExpandBits
+func DecodeTiles(r io.Reader, format TileFormat) (*Tiles, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return extractor.ExtractTileData(data, format), nil
+}
+
+// EncodeTiles writes tiles as binary tile data of the format, color indexes which don't fit the format are an error.
+// 1bpp tiles are expected to use color indexes 0 and 1, see PackBits
+func EncodeTiles(w io.Writer, tiles *Tiles, format TileFormat) error {
+	colors := 1 << extractor.CodecFor(format).BitsPerPixel()
+	for i, tile := range tiles.Data {
+		for _, pixel := range tile {
+			if int(pixel) >= colors {
+				return fmt.Errorf("tile %d has color index %d, %s tiles have at most %d colors", i, pixel, format, colors)
+			}
+		}
+	}
+
+	_, err := w.Write(extractor.EncodeTileData(tiles, format))
+	return err
+}
+
+// Compress compresses binary tile data, see Compression
+func Compress(data []byte, compression Compression) ([]byte, error) {
+	return extractor.CompressorFor(compression).Compress(data)
+}
+
+// Decompress decompresses binary tile data, truncated or invalid data is an error
+func Decompress(data []byte, compression Compression) ([]byte, error) {
+	return extractor.CompressorFor(compression).Decompress(data)
+}
+
+// DecodeMetatiles reads binary metatile data.
+// attributes: CGB attributes stored in the same format as tile indexes, may be nil
+// refs: tile refs of the result, indexes without a ref are reported in Metatiles.AbsentTiles
+func DecodeMetatiles(r io.Reader, attributes io.Reader, refs TileRefs, format MetatileFormat) (*Metatiles, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributeData []byte
+	if attributes != nil {
+		attributeData, err = io.ReadAll(attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decodeMetatiles(data, attributeData, refs, format)
+}
+
+// ExpandBits replaces color indexes 0 and 1 of decoded 1bpp tiles with the colors
+func ExpandBits(tiles *Tiles, colors BitColors) {
+	extractor.ExpandBits(tiles, colors)
+}
+
+// PackBits converts tiles which only use the colors to 1bpp color indexes, other colors are an error
+func PackBits(tiles *Tiles, colors BitColors) (*Tiles, error) {
+	return extractor.PackBits(tiles, colors)
+}
+
+func decodeMetatiles(data, attributes []byte, refs TileRefs, format MetatileFormat) (*Metatiles, error) {
+	if attributes != nil && len(attributes) != len(data) {
+		return nil, errors.New("attribute data size doesn't match metatile data size")
+	}
+	mtiles := extractor.ExtractMetatileData(data, attributes, refs, format)
+	if mtiles == nil {
+		return nil, fmt.Errorf("metatile data size is not a multiple of %d", format.Size.TileCount())
+	}
+	return mtiles, nil
+}
+
+// EncodeMetatiles writes tile indexes of the metatiles to w and their CGB attributes to attributes.
+// Attributes are only written if any metatile has them, attributes may be nil if none of them do
+func EncodeMetatiles(w io.Writer, attributes io.Writer, metatiles []Metatile, format MetatileFormat) error {
+	data, attributeData := extractor.EncodeMetatileData(metatiles, format)
+	if attributeData != nil && attributes == nil {
+		return errors.New("metatiles have attributes, but there is no attribute writer")
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if attributeData != nil {
+		_, err := attributes.Write(attributeData)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package tileset
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"path"
+	"sync"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+)
+
+// TileOptions control loading of tile data files
+type TileOptions struct {
+	// Format of binary files, unless the name specifies another one, see TileFormatOf
+	Format TileFormat
+	// Compression of binary files, unless the name specifies another one, see CompressionOf
+	Compression Compression
+	// Colors of the bits of 1bpp tiles, the zero value means DefaultBitColors
+	BitColors BitColors
+	// Colors of PNG tilesheets, pixels of other colors are an error
+	Palette []color.Color
+	// Report invalid values of .tile.json files as errors instead of skipping them
+	Strict bool
+}
+
+// LoadTiles loads tile data from a .tile.json file, a PNG tilesheet or a binary file depending on the extension.
+// The format of files without an extension is detected from their contents, see DetectExtension.
+// Binary files are decompressed first. Color indexes of 1bpp tiles are replaced with opts.BitColors
+func LoadTiles(fsys fs.FS, name string, opts TileOptions) (*Tiles, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := path.Ext(common.TrimCompression(name))
+	if len(ext) == 0 {
+		ext = DetectExtension(data)
+	}
+	switch ext {
+	case common.ExtensionJSON:
+		return serializer.ParseTileDataBytes(name, data, opts.Strict)
+	case common.ExtensionPNG:
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, common.Wrap(err, "failed to decode image")
+		}
+		return ImageToTilesExact(img, opts.Palette)
+	default:
+		compression := CompressionOf(name, opts.Compression)
+		data, err = Decompress(data, compression)
+		if err != nil {
+			return nil, common.Wrap(err, "failed to decompress "+compression.String()+" data")
+		}
+		format := TileFormatOf(name, opts.Format)
+		tiles, err := DecodeTiles(bytes.NewReader(data), format)
+		if err == nil && format == TileFormat1bpp {
+			colors := opts.BitColors
+			if colors == (BitColors{}) {
+				colors = DefaultBitColors
+			}
+			ExpandBits(tiles, colors)
+		}
+		return tiles, err
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// DetectExtension guesses the file extension from its contents: ".png", ".json" or ".chr" for binary tile data
+func DetectExtension(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return common.ExtensionPNG
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		return common.ExtensionJSON
+	default:
+		return common.ExtensionTileData
+	}
+}
+
+// LoadImage loads a PNG image
+func LoadImage(fsys fs.FS, name string) (image.Image, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, common.Wrap(err, "failed to open file")
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, common.Wrap(err, "failed to decode image")
+	}
+	return img, nil
+}
+
+// LoadMetatiles loads binary metatile data, see DecodeMetatiles. attributes is the name of the attribute file or empty
+func LoadMetatiles(fsys fs.FS, name, attributes string, refs TileRefs, format MetatileFormat) (*Metatiles, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributeData []byte
+	if len(attributes) != 0 {
+		attributeData, err = fs.ReadFile(fsys, attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decodeMetatiles(data, attributeData, refs, format)
+}
+
+// LoadMap loads a .map.json file and the files referenced by it
+func LoadMap(fsys fs.FS, name string, strict bool) (*TileMap, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, common.Wrap(err, "could not read file")
+	}
+	tileMap, err := serializer.ParseMapDataBytes(name, data, strict)
+	if err != nil {
+		return nil, err
+	}
+
+	err = LoadMapFiles(fsys, tileMap, strict)
+	if err != nil {
+		return nil, err
+	}
+	return tileMap, nil
+}
+
+// LoadMapFiles loads metatiles, cells and attributes referenced by the map
+func LoadMapFiles(fsys fs.FS, tileMap *TileMap, strict bool) error {
+	if len(tileMap.MetatileFile) != 0 {
+		data, err := fs.ReadFile(fsys, tileMap.MetatileFile)
+		if err == nil {
+			tileMap.Metatiles, err = serializer.ParseMetatileDataBytes(tileMap.MetatileFile, data, strict)
+		}
+		if err != nil {
+			return common.Wrap(err, "failed to load metatiles")
+		}
+	}
+	if len(tileMap.CellFile) != 0 {
+		cells, err := fs.ReadFile(fsys, tileMap.CellFile)
+		if err != nil {
+			return common.Wrap(err, "failed to load map data")
+		}
+		tileMap.Cells = cells
+	}
+	if len(tileMap.AttributeFile) != 0 {
+		attributes, err := fs.ReadFile(fsys, tileMap.AttributeFile)
+		if err != nil {
+			return common.Wrap(err, "failed to load attribute data")
+		}
+		tileMap.Attributes = make([]TileAttributes, 0, len(attributes))
+		for _, attr := range attributes {
+			tileMap.Attributes = append(tileMap.Attributes, TileAttributes(attr))
+		}
+	}
+
+	if len(tileMap.Cells) < tileMap.Width*tileMap.Height {
+		return fmt.Errorf("map has %d cells, expected %d", len(tileMap.Cells), tileMap.Width*tileMap.Height)
+	}
+
+	return nil
+}
+
+// TileSource provides tiles referenced by tile refs for rendering
+type TileSource interface {
+	// Tile returns color indexes of the pixels of a tile of the file
+	Tile(file string, index uint8) ([]byte, error)
+}
+
+// FSTiles loads tile data files from an fs.FS, see LoadTiles. Files are kept in memory once loaded.
+// FSTiles is safe for concurrent use
+type FSTiles struct {
+	fsys  fs.FS
+	opts  TileOptions
+	mutex sync.Mutex
+	files map[string]*Tiles
+}
+
+func NewFSTiles(fsys fs.FS, opts TileOptions) *FSTiles {
+	return &FSTiles{
+		fsys:  fsys,
+		opts:  opts,
+		files: map[string]*Tiles{},
+	}
+}
+
+func (t *FSTiles) Tile(file string, index uint8) ([]byte, error) {
+	t.mutex.Lock()
+	tiles, ok := t.files[file]
+	t.mutex.Unlock()
+	if !ok {
+		var err error
+		tiles, err = LoadTiles(t.fsys, file, t.opts)
+		if err != nil {
+			return nil, err
+		}
+		t.mutex.Lock()
+		t.files[file] = tiles
+		t.mutex.Unlock()
+	}
+
+	if int(index) >= len(tiles.Data) {
+		return nil, fmt.Errorf("tile index %d out of bounds: %s", index, file)
+	}
+	return tiles.Data[index], nil
+}
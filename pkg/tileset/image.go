@@ -0,0 +1,138 @@
+package tileset
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/extractor"
+)
+
+const maxReportedPixels = 16
+
+// ImageToTiles splits the image into tiles, pixels are mapped to the closest palette colors
+func ImageToTiles(img image.Image, palette []color.Color) (*Tiles, error) {
+	model := color.Palette(palette)
+	return imageToTiles(img, palette, func(c color.Color) (int, bool) {
+		return model.Index(c), true
+	})
+}
+
+// ImageToTilesExact splits the image into tiles, pixels which don't match any palette color are an error
+func ImageToTilesExact(img image.Image, palette []color.Color) (*Tiles, error) {
+	return imageToTiles(img, palette, func(c color.Color) (int, bool) {
+		r, g, b, a := c.RGBA()
+		for i := range palette {
+			pr, pg, pb, pa := palette[i].RGBA()
+			if r == pr && g == pg && b == pb && a == pa {
+				return i, true
+			}
+		}
+		return 0, false
+	})
+}
+
+func imageToTiles(img image.Image, palette []color.Color, index func(color.Color) (int, bool)) (*Tiles, error) {
+	bounds := img.Bounds()
+	if bounds.Dx()%common.TileSizePx != 0 || bounds.Dy()%common.TileSizePx != 0 {
+		return nil, fmt.Errorf("image size %dx%d is not a multiple of tile size", bounds.Dx(), bounds.Dy())
+	}
+	if len(palette) == 0 {
+		return nil, errors.New("empty palette")
+	}
+
+	width, height := bounds.Dx()/common.TileSizePx, bounds.Dy()/common.TileSizePx
+	result := &Tiles{
+		Data:    make([][]byte, 0, width*height),
+		Palette: palette,
+		Size:    common.MemorySizeFrom(float64(width*height)*common.BitsPerTile, common.Bytes),
+	}
+	unknown, unknownCount := []string{}, 0
+	for tileY := 0; tileY < height; tileY++ {
+		for tileX := 0; tileX < width; tileX++ {
+			tile := make([]byte, 0, common.BitsPerTile)
+			x, y := bounds.Min.X+tileX*common.TileSizePx, bounds.Min.Y+tileY*common.TileSizePx
+			for row := 0; row < common.TileSizePx; row++ {
+				for column := 0; column < common.TileSizePx; column++ {
+					c := img.At(x+column, y+row)
+					i, ok := index(c)
+					if !ok && len(unknown) < maxReportedPixels {
+						r, g, b, _ := c.RGBA()
+						unknown = append(unknown, fmt.Sprintf("(%d, %d): %02x%02x%02x", x+column, y+row, r>>8, g>>8, b>>8))
+					}
+					if !ok {
+						unknownCount++
+					}
+					tile = append(tile, uint8(i))
+				}
+			}
+			result.Data = append(result.Data, tile)
+		}
+	}
+
+	if unknownCount != 0 {
+		return nil, fmt.Errorf("%d pixels do not match any palette color: %s", unknownCount, strings.Join(unknown, ", "))
+	}
+
+	return result, nil
+}
+
+// ImageToMetatiles splits the image into metatiles of the given size and removes duplicate tiles.
+// With detectFlips flipped copies of a tile are also duplicates, the flips are stored in metatile attributes.
+// Tile indexes of the result start from 0
+func ImageToMetatiles(img image.Image, palette []color.Color, size MetatileSize, detectFlips bool) (*Tiles, *Metatiles, error) {
+	bounds := img.Bounds()
+	if size.TileCount() <= 0 {
+		return nil, nil, fmt.Errorf("invalid metatile size %dx%d", size.Width, size.Height)
+	}
+	if bounds.Dx()%(size.Width*common.TileSizePx) != 0 || bounds.Dy()%(size.Height*common.TileSizePx) != 0 {
+		return nil, nil, fmt.Errorf("image size %dx%d is not a multiple of metatile size", bounds.Dx(), bounds.Dy())
+	}
+
+	sheet, err := ImageToTiles(img, palette)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// reorder tiles so that every size.TileCount() consecutive tiles form a metatile
+	ordered := &Tiles{
+		Data:    make([][]byte, 0, len(sheet.Data)),
+		Palette: palette,
+	}
+	rowTiles := bounds.Dx() / common.TileSizePx
+	for y := 0; y < bounds.Dy()/common.TileSizePx; y += size.Height {
+		for x := 0; x < rowTiles; x += size.Width {
+			for row := 0; row < size.Height; row++ {
+				start := (y+row)*rowTiles + x
+				ordered.Data = append(ordered.Data, sheet.Data[start:start+size.Width]...)
+			}
+		}
+	}
+
+	tiles, remap := extractor.DeduplicateTiles(ordered, detectFlips)
+	if len(tiles.Data) > common.MaxTilesPerFile {
+		return nil, nil, fmt.Errorf("image has %d unique tiles, at most %d are supported", len(tiles.Data), common.MaxTilesPerFile)
+	}
+
+	mtiles := NewMetatiles()
+	mtiles.Palette = palette
+	mtiles.Size = size
+	for i := 0; i < len(remap); i += size.TileCount() {
+		mtile := Metatile{Tiles: make([]uint8, 0, size.TileCount())}
+		for _, r := range remap[i : i+size.TileCount()] {
+			mtile.Tiles = append(mtile.Tiles, uint8(r.Index))
+			if r.Flip != 0 && len(mtile.Attributes) == 0 {
+				mtile.Attributes = make([]TileAttributes, size.TileCount())
+			}
+		}
+		for j := range mtile.Attributes {
+			mtile.Attributes[j] = TileAttributes(remap[i+j].Flip)
+		}
+		mtiles.Metatiles = append(mtiles.Metatiles, mtile)
+	}
+
+	return tiles, mtiles, nil
+}
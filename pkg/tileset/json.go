@@ -0,0 +1,84 @@
+package tileset
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+)
+
+// Reading JSON files. In strict mode every invalid value is an error,
+// otherwise invalid values are skipped or replaced with defaults where possible.
+// Problems found in a file are returned as ParseErrors
+
+// ReadTileJSON reads a .tile.json file
+func ReadTileJSON(r io.Reader, strict bool) (*Tiles, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.ParseTileDataBytes("", data, strict)
+}
+
+// ReadMetatileJSON reads a .mtile.json file
+func ReadMetatileJSON(r io.Reader, strict bool) (*Metatiles, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.ParseMetatileDataBytes("", data, strict)
+}
+
+// ReadMapJSON reads a .map.json file. Files referenced by the map are not loaded, see LoadMapFiles
+func ReadMapJSON(r io.Reader, strict bool) (*TileMap, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.ParseMapDataBytes("", data, strict)
+}
+
+// LoadJSON loads a .tile.json, .mtile.json or .map.json file depending on its "type" field.
+// Files referenced by maps are loaded from fsys. The result is *Tiles, *Metatiles or *TileMap
+func LoadJSON(fsys fs.FS, name string, strict bool) (any, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, common.Wrap(err, "could not read file")
+	}
+	result, err := serializer.ParseData(name, data, strict)
+	if err != nil {
+		return nil, err
+	}
+	switch result := result.(type) {
+	case *Tiles, *Metatiles:
+		return result, nil
+	case *TileMap:
+		if err := LoadMapFiles(fsys, result, strict); err != nil {
+			return nil, err
+		}
+		return result, nil
+	default:
+		return nil, errors.New("not a tile, metatile or map data file")
+	}
+}
+
+// WriteTileJSON writes tiles as a .tile.json file
+func WriteTileJSON(w io.Writer, tiles *Tiles) error {
+	_, err := w.Write(serializer.SerializeTileData(tiles).MarshalTo(nil))
+	return err
+}
+
+// WriteMapJSON writes the map as a .map.json file with inline cells and attributes.
+// Metatiles are referenced by tileMap.MetatileFile
+func WriteMapJSON(w io.Writer, tileMap *TileMap) error {
+	_, err := w.Write(serializer.SerializeMapData(tileMap).MarshalTo(nil))
+	return err
+}
+
+// WriteMetatileJSON writes metatiles as a .mtile.json file
+func WriteMetatileJSON(w io.Writer, mtiles *Metatiles) error {
+	_, err := w.Write(serializer.SerializeMetatileData(mtiles.Palette, mtiles).MarshalTo(nil))
+	return err
+}
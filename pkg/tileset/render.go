@@ -0,0 +1,249 @@
+package tileset
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/extractor"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+)
+
+// Rendered images are common.OutTilesPerRow tiles or metatiles wide
+
+// CheckColors returns an error if a pixel of the tiles has a color index outside of tileData.Palette,
+// e.g. when 4bpp tiles are paired with a four color palette
+func CheckColors(tileData *Tiles) error {
+	for i, tile := range tileData.Data {
+		if pixel := serializer.InvalidPixel(tile, len(tileData.Palette)); pixel >= 0 {
+			return fmt.Errorf("tile %d: color index %d of pixel %d is outside of the %d-color palette", i, tile[pixel], pixel, len(tileData.Palette))
+		}
+	}
+	return nil
+}
+
+// RenderTiles draws tiles with tileData.Palette, see CheckColors
+func RenderTiles(tileData *Tiles) (*image.Paletted, error) {
+	if err := CheckColors(tileData); err != nil {
+		return nil, err
+	}
+	width := common.OutTilesPerRow
+	if len(tileData.Data) < width {
+		width = len(tileData.Data)
+	}
+	if width == 0 {
+		return image.NewPaletted(image.Rectangle{}, []color.Color(tileData.Palette)), nil
+	}
+
+	height := len(tileData.Data) / width
+	if len(tileData.Data)%width != 0 {
+		height++
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, width*common.TileSizePx, height*common.TileSizePx),
+		[]color.Color(tileData.Palette))
+	x, y := 0, 0
+	palette := outPalette{colors: tileData.Palette, size: len(tileData.Palette)}
+	for _, tile := range tileData.Data {
+		writeTileToImage(img, palette, tile, 0, x, y)
+		x += common.TileSizePx
+		if x >= width*common.TileSizePx {
+			x %= width * common.TileSizePx
+			y += common.TileSizePx
+		}
+	}
+
+	return img, nil
+}
+
+// RenderMetatiles draws metatiles with tiles from src. Tiles without a ref or which can't be loaded are transparent,
+// tiles with color indexes outside of the palette are an error
+func RenderMetatiles(src TileSource, tileset *Metatiles) (*image.Paletted, error) {
+	width := common.OutTilesPerRow
+	if len(tileset.Metatiles) < width {
+		width = len(tileset.Metatiles)
+	}
+	if width == 0 {
+		return image.NewPaletted(image.Rectangle{}, nil), nil
+	}
+
+	height := len(tileset.Metatiles) / width
+	if len(tileset.Metatiles)%width != 0 {
+		height++
+	}
+
+	actualPalette := newOutPalette(tileset.Palette, tileset.CGBPalettes, tileset.AbsentTiles.Size() != 0)
+
+	mtileWidthPx, mtileHeightPx := tileset.Size.Width*common.TileSizePx, tileset.Size.Height*common.TileSizePx
+	img := image.NewPaletted(image.Rect(0, 0, width*mtileWidthPx, height*mtileHeightPx),
+		[]color.Color(actualPalette.colors))
+
+	x, y := 0, 0
+
+	for i, mtile := range tileset.Metatiles {
+		if err := writeMetatile(src, tileset, img, mtile, actualPalette, x, y); err != nil {
+			return nil, common.Wrap(err, fmt.Sprintf("metatile %d", i))
+		}
+		x += mtileWidthPx
+		if x >= width*mtileWidthPx {
+			x %= width * mtileWidthPx
+			y += mtileHeightPx
+		}
+	}
+
+	return img, nil
+}
+
+// RenderMap draws a map of tiles or metatiles with tiles from src, the map's palettes take precedence over the metatiles' ones.
+// Like in RenderMetatiles, tiles with color indexes outside of the palette are an error
+func RenderMap(src TileSource, tileMap *TileMap) (*image.Paletted, error) {
+	cellWidthPx, cellHeightPx := common.TileSizePx, common.TileSizePx
+	plt, cgbPalettes := tileMap.Palette, tileMap.CGBPalettes
+	if tileMap.Metatiles != nil {
+		cellWidthPx *= tileMap.Metatiles.Size.Width
+		cellHeightPx *= tileMap.Metatiles.Size.Height
+		if len(plt) == 0 {
+			plt = tileMap.Metatiles.Palette
+		}
+		if len(cgbPalettes) == 0 {
+			cgbPalettes = tileMap.Metatiles.CGBPalettes
+		}
+	}
+
+	actualPalette := newOutPalette(plt, cgbPalettes, true)
+	img := image.NewPaletted(image.Rect(0, 0, tileMap.Width*cellWidthPx, tileMap.Height*cellHeightPx),
+		[]color.Color(actualPalette.colors))
+
+	for i := 0; i < len(tileMap.Cells) && i < tileMap.Width*tileMap.Height; i++ {
+		x, y := i%tileMap.Width*cellWidthPx, i/tileMap.Width*cellHeightPx
+		index := tileMap.Cells[i]
+		var err error
+		if tileMap.Metatiles == nil {
+			var attr TileAttributes
+			if i < len(tileMap.Attributes) {
+				attr = actualPalette.clampAttributes(tileMap.Attributes[i])
+			}
+			err = writeRefTile(src, tileMap.Refs, img, tileMap.Addressing.RefIndex(index), attr, actualPalette, x, y)
+		} else if int(index) < len(tileMap.Metatiles.Metatiles) {
+			err = writeMetatile(src, tileMap.Metatiles, img, tileMap.Metatiles.Metatiles[index], actualPalette, x, y)
+		}
+		if err != nil {
+			return nil, common.Wrap(err, fmt.Sprintf("cell %d", i))
+		}
+	}
+
+	return img, nil
+}
+
+func writeMetatile(src TileSource, tileset *Metatiles, img *image.Paletted, mtile Metatile, palette outPalette, x, y int) error {
+	for i := 0; i < len(mtile.Tiles) && i < tileset.Size.TileCount(); i++ {
+		tileX, tileY := x+i%tileset.Size.Width*common.TileSizePx, y+i/tileset.Size.Width*common.TileSizePx
+		attr := palette.clampAttributes(mtile.GetAttributes(i))
+		err := writeRefTile(src, tileset.Refs, img, tileset.Addressing.RefIndex(mtile.Tiles[i]), attr, palette, tileX, tileY)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index: tile index converted with common.AddressingMode.RefIndex
+func writeRefTile(src TileSource, refs TileRefs, img *image.Paletted, index uint8, attr TileAttributes, palette outPalette, x, y int) error {
+	ref, ok := refs.Find(attr.Bank(), index)
+	if !ok || len(ref.File) == 0 {
+		return nil
+	}
+
+	tileIndex := ref.Offset + (index - ref.Range.Start)
+	tile, err := src.Tile(ref.File, tileIndex)
+	if err != nil {
+		return nil
+	}
+	if pixel := serializer.InvalidPixel(tile, palette.size); pixel >= 0 {
+		return fmt.Errorf("tile %d: color index %d of pixel %d is outside of the %d-color palette: %s", tileIndex, tile[pixel], pixel, palette.size, ref.File)
+	}
+
+	writeTileToImage(img, palette, tile, attr, x, y)
+	return nil
+}
+
+type outPalette struct {
+	colors []color.Color
+	// Number of colors available to a tile, CGB palettes have common.CGBPaletteSize colors each
+	size int
+	// Number of CGB palettes
+	paletteCount uint8
+}
+
+func newOutPalette(palette []color.Color, cgbPalettes [][]color.Color, transparent bool) outPalette {
+	if len(cgbPalettes) != 0 {
+		palette = joinCGBPalettes(cgbPalettes)
+	}
+
+	result := outPalette{size: len(palette), paletteCount: uint8(len(cgbPalettes))}
+	if len(cgbPalettes) != 0 {
+		result.size = common.CGBPaletteSize
+	}
+	// There is no room for the transparent color in full 256-color palettes, absent tiles are drawn with color 0
+	if transparent && len(palette) < common.MaxPaletteColors {
+		result.colors = addTransparent(palette)
+	} else {
+		result.colors = make([]color.Color, len(palette))
+		copy(result.colors, palette)
+	}
+	return result
+}
+
+func addTransparent(palette []color.Color) []color.Color {
+	actualPalette := make([]color.Color, 0, len(palette)+1)
+	actualPalette = append(actualPalette, color.Transparent)
+	actualPalette = append(actualPalette, palette...)
+	return actualPalette
+}
+
+// Fall back to the first palette if the attributes refer to a missing CGB palette
+func (p outPalette) clampAttributes(attr TileAttributes) TileAttributes {
+	if attr.Palette() >= p.paletteCount {
+		attr &^= common.AttrPaletteMask
+	}
+	return attr
+}
+
+// Concatenate CGB palettes, each palette is padded to common.CGBPaletteSize colors
+func joinCGBPalettes(palettes [][]color.Color) []color.Color {
+	result := make([]color.Color, 0, len(palettes)*common.CGBPaletteSize)
+	for _, plt := range palettes {
+		for i := 0; i < common.CGBPaletteSize; i++ {
+			if i < len(plt) {
+				result = append(result, plt[i])
+			} else {
+				result = append(result, color.Black)
+			}
+		}
+	}
+	return result
+}
+
+func (p outPalette) getColorIndex(paletteIndex, rawIndex uint8) uint8 {
+	rawIndex += paletteIndex * common.CGBPaletteSize
+	if p.colors[0] != color.Transparent {
+		return rawIndex
+	}
+	return rawIndex + 1
+}
+
+func writeTileToImage(image *image.Paletted, palette outPalette, tile []byte, attr TileAttributes, x, y int) {
+	if len(tile) != common.BitsPerTile {
+		return
+	}
+	if flip := attr.Flip(); flip != 0 {
+		tile = extractor.FlipTile(tile, flip)
+	}
+
+	for row := 0; row < common.TileSizePx; row++ {
+		for column := 0; column < common.TileSizePx; column++ {
+			image.SetColorIndex(x+column, y+row, palette.getColorIndex(attr.Palette(), tile[row*common.TileSizePx+column]))
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package tileset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+)
+
+// Tiled map editor support: tiles and metatiles are exported as TSX tilesets of the images drawn by
+// RenderTiles and RenderMetatiles, tile layers of TMX maps are imported as maps
+
+// Properties of exported tilesets naming the data files of the tiles, relative to the TSX file
+const (
+	TiledTilesProperty     = "tile_data"
+	TiledMetatilesProperty = "metatile_data"
+)
+
+type (
+	// Custom property of a metatile, such as collision
+	Property     = common.Property
+	PropertyType = common.PropertyType
+)
+
+const (
+	PropertyString = common.PropertyString
+	PropertyInt    = common.PropertyInt
+	PropertyFloat  = common.PropertyFloat
+	PropertyBool   = common.PropertyBool
+)
+
+// WriteTilesTSX writes a tileset of the tiles drawn on image. tileFile is the tile data file read by ReadTMX,
+// paths are relative to the TSX file
+func WriteTilesTSX(w io.Writer, tiles *Tiles, name, image, tileFile string) error {
+	tsx := newTiledTileset(name, image, len(tiles.Data), TileSizePx, TileSizePx)
+	if len(tileFile) != 0 {
+		tsx.Properties = []Property{{Name: TiledTilesProperty, Value: tileFile}}
+	}
+	_, err := w.Write(serializer.SerializeTSX(tsx))
+	return err
+}
+
+// WriteMetatileTSX writes a tileset with a tile for each metatile drawn on image, metatile properties become tile properties.
+// metatileFile is the .mtile.json file read by ReadTMX, paths are relative to the TSX file
+func WriteMetatileTSX(w io.Writer, mtiles *Metatiles, name, image, metatileFile string) error {
+	tsx := newTiledTileset(name, image, len(mtiles.Metatiles), mtiles.Size.Width*TileSizePx, mtiles.Size.Height*TileSizePx)
+	if len(metatileFile) != 0 {
+		tsx.Properties = []Property{{Name: TiledMetatilesProperty, Value: metatileFile}}
+	}
+	for _, mtile := range mtiles.Metatiles {
+		tsx.TileProperties = append(tsx.TileProperties, mtile.Properties)
+	}
+	_, err := w.Write(serializer.SerializeTSX(tsx))
+	return err
+}
+
+// Rendered images are common.OutTilesPerRow tiles wide
+func newTiledTileset(name, image string, count, tileWidth, tileHeight int) *serializer.TiledTileset {
+	columns := min(count, common.OutTilesPerRow)
+	rows := 0
+	if columns != 0 {
+		rows = (count + columns - 1) / columns
+	}
+	return &serializer.TiledTileset{
+		Name:        name,
+		TileWidth:   tileWidth,
+		TileHeight:  tileHeight,
+		TileCount:   count,
+		Columns:     columns,
+		Image:       image,
+		ImageWidth:  columns * tileWidth,
+		ImageHeight: rows * tileHeight,
+	}
+}
+
+// ReadTMX reads a tile layer of a TMX map, the first one if layer is empty. All cells must use a single tileset
+// written by WriteTilesTSX or WriteMetatileTSX, the TSX file and the data files it names are loaded from fsys.
+//
+// Metatile tilesets make a map of metatile indexes, properties of the metatiles are replaced with the tile
+// properties of the tileset, so that properties edited in Tiled are kept. Tile tilesets make a map of tile indexes,
+// flipped tiles get CGB flip attributes. Empty cells are index 0
+func ReadTMX(fsys fs.FS, name, layer string, strict bool) (*TileMap, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, common.Wrap(err, "could not read file")
+	}
+	tmx, err := serializer.ParseTMX(name, data, layer)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := tiledTilesetOf(tmx)
+	if err != nil {
+		return nil, common.Wrap(err, name)
+	}
+	tsx, tsxPath := ref.Tileset, name
+	if tsx == nil {
+		tsxPath = path.Join(path.Dir(name), ref.Source)
+		data, err = fs.ReadFile(fsys, tsxPath)
+		if err != nil {
+			return nil, common.Wrap(err, "could not read tileset")
+		}
+		tsx, err = serializer.ParseTSX(tsxPath, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := NewTileMap()
+	result.Width, result.Height = tmx.Width, tmx.Height
+	if file := tiledProperty(tsx.Properties, TiledMetatilesProperty); len(file) != 0 {
+		result.MetatileFile = path.Join(path.Dir(tsxPath), file)
+		data, err = fs.ReadFile(fsys, result.MetatileFile)
+		if err == nil {
+			result.Metatiles, err = serializer.ParseMetatileDataBytes(result.MetatileFile, data, strict)
+		}
+		if err != nil {
+			return nil, common.Wrap(err, "failed to load metatiles")
+		}
+		for i := range result.Metatiles.Metatiles {
+			result.Metatiles.Metatiles[i].Properties = nil
+			if i < len(tsx.TileProperties) {
+				result.Metatiles.Metatiles[i].Properties = tsx.TileProperties[i]
+			}
+		}
+	} else if file := tiledProperty(tsx.Properties, TiledTilesProperty); len(file) != 0 && tsx.TileCount != 0 {
+		result.Refs.Insert(TileRef{
+			File:  path.Join(path.Dir(tsxPath), file),
+			Range: IndexRange{End: uint8(min(tsx.TileCount, MaxTilesPerFile) - 1)},
+		})
+	} else {
+		return nil, fmt.Errorf("tileset has neither %s nor %s property: %s", TiledMetatilesProperty, TiledTilesProperty, tsxPath)
+	}
+
+	result.Cells = make([]uint8, len(tmx.Cells))
+	flipped := false
+	attributes := make([]TileAttributes, len(tmx.Cells))
+	for i, gid := range tmx.Cells {
+		if gid == 0 {
+			continue
+		}
+		id := gid&serializer.TiledGIDMask - ref.FirstGID
+		if int(id) >= min(tsx.TileCount, MaxTilesPerFile) {
+			return nil, fmt.Errorf("cell %d: tile %d out of range: %s", i, id, name)
+		}
+		result.Cells[i] = uint8(id)
+
+		if gid&^serializer.TiledGIDMask == 0 {
+			continue
+		} else if result.Metatiles != nil || gid&serializer.TiledFlipDiagonal != 0 {
+			return nil, fmt.Errorf("cell %d: only tiles may be flipped and only horizontally or vertically: %s", i, name)
+		}
+		flipped = true
+		if gid&serializer.TiledFlipX != 0 {
+			attributes[i] |= TileAttributes(FlipX)
+		}
+		if gid&serializer.TiledFlipY != 0 {
+			attributes[i] |= TileAttributes(FlipY)
+		}
+	}
+	if flipped {
+		result.Attributes = attributes
+	}
+	return result, nil
+}
+
+// tiledTilesetOf returns the tileset used by the cells of the map
+func tiledTilesetOf(tmx *serializer.TiledMap) (*serializer.TiledTilesetRef, error) {
+	var result *serializer.TiledTilesetRef
+	for _, gid := range tmx.Cells {
+		gid &= serializer.TiledGIDMask
+		if gid == 0 {
+			continue
+		}
+		var ref *serializer.TiledTilesetRef
+		for i := range tmx.Tilesets {
+			if tmx.Tilesets[i].FirstGID <= gid && (ref == nil || tmx.Tilesets[i].FirstGID > ref.FirstGID) {
+				ref = &tmx.Tilesets[i]
+			}
+		}
+		if ref == nil {
+			return nil, fmt.Errorf("no tileset for tile id %d", gid)
+		} else if result != nil && result != ref {
+			return nil, errors.New("layer uses more than one tileset")
+		}
+		result = ref
+	}
+	if result == nil && len(tmx.Tilesets) != 0 {
+		result = &tmx.Tilesets[0]
+	} else if result == nil {
+		return nil, errors.New("map has no tilesets")
+	}
+	return result, nil
+}
+
+func tiledProperty(props []Property, name string) string {
+	for _, prop := range props {
+		if prop.Name == name {
+			return prop.Value
+		}
+	}
+	return ""
+}
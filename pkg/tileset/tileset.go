@@ -0,0 +1,114 @@
+// Package tileset reads, writes and renders tile data of the Game Boy, NES, SNES, PC Engine and GBA, metatiles and maps.
+//
+// Binary data is read from io.Reader and written to io.Writer. Files referenced by metatiles and maps,
+// such as tile data files of tile refs, are loaded from an fs.FS by their names
+package tileset
+
+import (
+	"github.com/Onlymiind/tileset_manager/internal/common"
+	"github.com/Onlymiind/tileset_manager/internal/serializer"
+)
+
+type (
+	// Tiles are stored as color indexes of each pixel, row by row
+	Tiles     = common.Tiles
+	Metatiles = common.Metatiles
+	// Tiles are stored row by row
+	Metatile = common.Metatile
+	// Background or level map
+	TileMap = common.TileMap
+	// TileRef maps a range of tile indexes to tiles of a file starting from Offset
+	TileRef = common.TileRef
+	// TileRefs maps tile indexes to tile data files, see TileRefs.Insert and TileRefs.Find
+	TileRefs = common.TileRefs
+	// Inclusive range of tile indexes
+	IndexRange = common.IndexRange
+	// Inclusive range of tile indexes in a VRAM bank, used for absent tiles
+	BankRange = common.BankRange
+	// OverlapError is returned when a tile ref overlaps a ref which is already present
+	OverlapError   = common.OverlapError
+	TileAttributes = common.TileAttributes
+	TileFlip       = common.TileFlip
+	MetatileSize   = common.MetatileSize
+	MetatileFormat = common.MetatileFormat
+	MetatileLayout = common.MetatileLayout
+	AddressingMode = common.AddressingMode
+	// Binary tile data format
+	TileFormat = common.TileFormat
+	// Color indexes of the 0 and 1 bits of 1bpp tiles
+	BitColors = common.BitColors
+	// Compression of binary tile data files
+	Compression = common.Compression
+	// ParseError describes a single problem found in a JSON file
+	ParseError = serializer.ParseError
+	// ParseErrors holds every problem found in a file
+	ParseErrors = serializer.ParseErrors
+)
+
+const (
+	LayoutRowMajor    = common.LayoutRowMajor
+	LayoutColumnMajor = common.LayoutColumnMajor
+	LayoutPlanar      = common.LayoutPlanar
+
+	Addressing8000 = common.Addressing8000
+	Addressing8800 = common.Addressing8800
+
+	TileFormatGB    = common.TileFormatGB
+	TileFormatNES   = common.TileFormatNES
+	TileFormatSNES4 = common.TileFormatSNES4
+	TileFormatPCE   = common.TileFormatPCE
+	TileFormatSNES8 = common.TileFormatSNES8
+	TileFormatGBA4  = common.TileFormatGBA4
+	TileFormatGBA8  = common.TileFormatGBA8
+	TileFormat1bpp  = common.TileFormat1bpp
+
+	CompressionNone = common.CompressionNone
+	CompressionRLE  = common.CompressionRLE
+	CompressionPB16 = common.CompressionPB16
+	CompressionLZSS = common.CompressionLZSS
+
+	FlipX  = common.FlipX
+	FlipY  = common.FlipY
+	FlipXY = common.FlipXY
+
+	AttrPaletteMask = common.AttrPaletteMask
+	AttrBank        = common.AttrBank
+	AttrPriority    = common.AttrPriority
+
+	TileSizePx       = common.TileSizePx
+	MaxTilesPerFile  = common.MaxTilesPerFile
+	MaxPaletteColors = common.MaxPaletteColors
+)
+
+// DefaultBitColors draw set bits of 1bpp tiles with color 3 and clear bits with color 0
+var DefaultBitColors = common.DefaultBitColors
+
+func NewTileRefs() TileRefs {
+	return common.NewTileRefs()
+}
+
+// NewMetatiles returns empty metatile data of the default 2x2 size
+func NewMetatiles() *Metatiles {
+	return common.NewMetatiles()
+}
+
+func NewTileMap() *TileMap {
+	return common.NewTileMap()
+}
+
+// TileFormatOf returns the tile format named by the second to last extension of the file, e.g. tiles.nes.chr,
+// or defaultFormat if there is no such extension. Compression extensions are skipped, e.g. tiles.nes.chr.pb16
+func TileFormatOf(name string, defaultFormat TileFormat) TileFormat {
+	return common.TileFormatOf(name, defaultFormat)
+}
+
+// CompressionOf returns the compression named by the last extension of the file, e.g. tiles.chr.pb16,
+// or defaultCompression if there is no such extension
+func CompressionOf(name string, defaultCompression Compression) Compression {
+	return common.CompressionOf(name, defaultCompression)
+}
+
+// DefaultFormat is the metatile format used when none is specified: 2x2 row-major metatiles with $8000 addressing
+func DefaultFormat() MetatileFormat {
+	return MetatileFormat{Size: MetatileSize{Width: common.DefaultMetatileSize, Height: common.DefaultMetatileSize}}
+}
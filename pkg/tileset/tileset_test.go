@@ -0,0 +1,406 @@
+package tileset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPalette = []color.Color{
+	color.RGBA{0xff, 0xff, 0xff, 0xff},
+	color.RGBA{0xaa, 0xaa, 0xaa, 0xff},
+	color.RGBA{0x55, 0x55, 0x55, 0xff},
+	color.RGBA{0x00, 0x00, 0x00, 0xff},
+}
+
+// testTiles returns 2bpp data of tiles filled with colors 0 to 3
+func testTiles() []byte {
+	var data []byte
+	for _, row := range [][2]byte{{0, 0}, {0xff, 0}, {0, 0xff}, {0xff, 0xff}} {
+		for i := 0; i < TileSizePx; i++ {
+			data = append(data, row[0], row[1])
+		}
+	}
+	return data
+}
+
+func TestTilesRoundTrip(t *testing.T) {
+	tiles, err := DecodeTiles(bytes.NewReader(testTiles()), TileFormatGB)
+	assert.NoError(t, err)
+	assert.Len(t, tiles.Data, 4)
+
+	var binary bytes.Buffer
+	assert.NoError(t, EncodeTiles(&binary, tiles, TileFormatGB))
+	assert.Equal(t, testTiles(), binary.Bytes())
+
+	tiles.Palette = testPalette
+	var json bytes.Buffer
+	assert.NoError(t, WriteTileJSON(&json, tiles))
+	parsed, err := ReadTileJSON(&json, true)
+	assert.NoError(t, err)
+	assert.Equal(t, tiles.Data, parsed.Data)
+	assert.Len(t, parsed.Palette, len(testPalette))
+}
+
+func TestTileColors(t *testing.T) {
+	palette := make([]color.Color, 0, MaxPaletteColors)
+	for i := 0; i < MaxPaletteColors; i++ {
+		palette = append(palette, color.RGBA{uint8(i), uint8(i), uint8(i), 0xff})
+	}
+	tile := make([]byte, TileSizePx*TileSizePx)
+	for i := range tile {
+		tile[i] = byte(i * 4)
+	}
+	tiles := &Tiles{Data: [][]byte{tile}, Palette: palette}
+
+	var binary bytes.Buffer
+	assert.Error(t, EncodeTiles(&binary, tiles, TileFormatGB))
+	assert.Error(t, EncodeTiles(&binary, tiles, TileFormatSNES4))
+	binary.Reset()
+	assert.NoError(t, EncodeTiles(&binary, tiles, TileFormatGBA8))
+	decoded, err := DecodeTiles(&binary, TileFormatGBA8)
+	assert.NoError(t, err)
+	assert.Equal(t, tiles.Data, decoded.Data)
+
+	var json bytes.Buffer
+	assert.NoError(t, WriteTileJSON(&json, tiles))
+	parsed, err := ReadTileJSON(&json, true)
+	assert.NoError(t, err)
+	assert.Equal(t, tiles.Data, parsed.Data)
+	assert.Len(t, parsed.Palette, MaxPaletteColors)
+
+	// Indexes outside of 4-color palettes are invalid
+	tiles.Palette = testPalette
+	json.Reset()
+	assert.NoError(t, WriteTileJSON(&json, tiles))
+	_, err = ReadTileJSON(&json, true)
+	assert.Error(t, err)
+
+	// Full palettes have no room for the transparent color of absent tiles
+	refs := NewTileRefs()
+	assert.NoError(t, refs.Insert(TileRef{File: "a.gba8.chr", Range: IndexRange{Start: 0, End: 0}}))
+	mtiles, err := DecodeMetatiles(bytes.NewReader([]byte{0, 1, 1, 0}), nil, refs, DefaultFormat())
+	assert.NoError(t, err)
+	mtiles.Palette = palette
+	binary.Reset()
+	assert.NoError(t, EncodeTiles(&binary, &Tiles{Data: [][]byte{tile}}, TileFormatGBA8))
+	src := NewFSTiles(fstest.MapFS{"a.gba8.chr": {Data: binary.Bytes()}}, TileOptions{Palette: palette, Strict: true})
+	img, err := RenderMetatiles(src, mtiles)
+	assert.NoError(t, err)
+	assert.Len(t, img.Palette, MaxPaletteColors)
+	assert.Equal(t, tile[TileSizePx+1], img.ColorIndexAt(1, 1))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(TileSizePx+1, 1))
+
+	// Palettes with fewer colors than the tile format are an error instead of a corrupt image
+	mtiles.Palette = testPalette
+	_, err = RenderMetatiles(src, mtiles)
+	assert.Error(t, err)
+	_, err = RenderTiles(&Tiles{Data: [][]byte{tile}, Palette: testPalette})
+	assert.Error(t, err)
+}
+
+func TestLoadTiles(t *testing.T) {
+	var json bytes.Buffer
+	tiles, err := DecodeTiles(bytes.NewReader(testTiles()), TileFormatGB)
+	assert.NoError(t, err)
+	tiles.Palette = testPalette
+	assert.NoError(t, WriteTileJSON(&json, tiles))
+	var nes bytes.Buffer
+	assert.NoError(t, EncodeTiles(&nes, tiles, TileFormatNES))
+	pb16, err := Compress(nes.Bytes(), CompressionPB16)
+	assert.NoError(t, err)
+	fsys := fstest.MapFS{
+		"a.chr":          {Data: testTiles()},
+		"b.tile.json":    {Data: json.Bytes()},
+		"c":              {Data: json.Bytes()},
+		"e.nes.chr":      {Data: nes.Bytes()},
+		"f.gb.chr":       {Data: testTiles()},
+		"g.nes.chr.pb16": {Data: pb16},
+	}
+
+	for _, name := range []string{"a.chr", "b.tile.json", "c", "e.nes.chr", "f.gb.chr", "g.nes.chr.pb16"} {
+		loaded, err := LoadTiles(fsys, name, TileOptions{Palette: testPalette, Strict: true})
+		assert.NoError(t, err, name)
+		assert.Equal(t, tiles.Data, loaded.Data, name)
+	}
+	loaded, err := LoadTiles(fstest.MapFS{"e.chr": {Data: nes.Bytes()}}, "e.chr", TileOptions{Format: TileFormatNES, Palette: testPalette, Strict: true})
+	assert.NoError(t, err)
+	assert.Equal(t, tiles.Data, loaded.Data)
+	_, err = LoadTiles(fsys, "d.chr", TileOptions{Palette: testPalette, Strict: true})
+	assert.Error(t, err)
+	loaded, err = LoadTiles(fstest.MapFS{"g.chr": {Data: pb16}}, "g.chr", TileOptions{Format: TileFormatNES, Compression: CompressionPB16})
+	assert.NoError(t, err)
+	assert.Equal(t, tiles.Data, loaded.Data)
+	_, err = LoadTiles(fstest.MapFS{"h.chr.lzss": {Data: pb16}}, "h.chr.lzss", TileOptions{})
+	assert.Error(t, err)
+
+	// 1bpp tiles are expanded to the bit colors
+	font := fstest.MapFS{"font.1bpp.chr": {Data: []byte{0xf0, 0, 0, 0, 0, 0, 0, 0}}}
+	loaded, err = LoadTiles(font, "font.1bpp.chr", TileOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3, 3, 3, 3, 0, 0, 0, 0}, loaded.Data[0][:TileSizePx])
+	loaded, err = LoadTiles(font, "font.1bpp.chr", TileOptions{BitColors: BitColors{1, 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2, 2, 2, 2, 1, 1, 1, 1}, loaded.Data[0][:TileSizePx])
+	_, err = PackBits(loaded, DefaultBitColors)
+	assert.Error(t, err)
+}
+
+func TestOffPalettePixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2*TileSizePx, TileSizePx))
+	draw.Draw(img, img.Bounds(), image.NewUniform(testPalette[0]), image.Point{}, draw.Src)
+	img.Set(9, 2, color.RGBA{0x40, 0x50, 0x60, 0xff})
+	var encoded bytes.Buffer
+	assert.NoError(t, png.Encode(&encoded, img))
+
+	_, err := ImageToTilesExact(img, testPalette)
+	assert.EqualError(t, err, "1 pixels do not match any palette color: (9, 2): 405060")
+	_, err = LoadTiles(fstest.MapFS{"a.png": {Data: encoded.Bytes()}}, "a.png", TileOptions{Palette: testPalette})
+	assert.EqualError(t, err, "1 pixels do not match any palette color: (9, 2): 405060")
+	tiles, err := ImageToTiles(img, testPalette)
+	assert.NoError(t, err)
+	assert.Len(t, tiles.Data, 2)
+
+	// Only the first 16 pixels are listed, tile by tile and row by row, the count includes all of them
+	expected := []string{}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < TileSizePx; x++ {
+			img.Set(x, y, color.RGBA{0x10, 0x20, 0x30, 0xff})
+			expected = append(expected, fmt.Sprintf("(%d, %d): 102030", x, y))
+		}
+	}
+	img.Set(TileSizePx, 0, color.RGBA{0x40, 0x50, 0x60, 0xff})
+	message := "18 pixels do not match any palette color: " + strings.Join(expected, ", ")
+	encoded.Reset()
+	assert.NoError(t, png.Encode(&encoded, img))
+
+	_, err = ImageToTilesExact(img, testPalette)
+	assert.EqualError(t, err, message)
+	_, err = LoadTiles(fstest.MapFS{"a.png": {Data: encoded.Bytes()}}, "a.png", TileOptions{Palette: testPalette})
+	assert.EqualError(t, err, message)
+}
+
+func TestMetatilesRoundTrip(t *testing.T) {
+	refs := NewTileRefs()
+	assert.NoError(t, refs.Insert(TileRef{File: "a.chr", Range: IndexRange{Start: 0, End: 3}}))
+	format := DefaultFormat()
+	data := []byte{0, 1, 2, 3, 3, 2, 1, 4}
+
+	mtiles, err := DecodeMetatiles(bytes.NewReader(data), nil, refs, format)
+	assert.NoError(t, err)
+	assert.Len(t, mtiles.Metatiles, 2)
+	assert.Equal(t, 1, mtiles.AbsentTiles.Size())
+
+	var encoded bytes.Buffer
+	assert.NoError(t, EncodeMetatiles(&encoded, nil, mtiles.Metatiles, format))
+	assert.Equal(t, data, encoded.Bytes())
+
+	mtiles.Metatiles[0].Attributes = []TileAttributes{0, AttrBank, 0, 0}
+	assert.Error(t, EncodeMetatiles(&encoded, nil, mtiles.Metatiles, format))
+
+	_, err = DecodeMetatiles(bytes.NewReader(data[:3]), nil, refs, format)
+	assert.Error(t, err)
+}
+
+func TestRenderMetatiles(t *testing.T) {
+	fsys := fstest.MapFS{"tiles/a.chr": {Data: testTiles()}}
+	refs := NewTileRefs()
+	assert.NoError(t, refs.Insert(TileRef{File: "tiles/a.chr", Range: IndexRange{Start: 0x10, End: 0x13}}))
+	mtiles, err := LoadMetatiles(fstest.MapFS{"m.mtile": {Data: []byte{0x10, 0x11, 0x12, 0x20}}}, "m.mtile", "", refs, DefaultFormat())
+	assert.NoError(t, err)
+	mtiles.Palette = testPalette
+
+	img, err := RenderMetatiles(NewFSTiles(fsys, TileOptions{Palette: testPalette, Strict: true}), mtiles)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*TileSizePx, img.Bounds().Dx())
+	assert.Equal(t, 2*TileSizePx, img.Bounds().Dy())
+	// Index 0 is transparent, since tile 0x20 is absent
+	assert.Equal(t, uint8(1), img.ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(2), img.ColorIndexAt(TileSizePx, 0))
+	assert.Equal(t, uint8(3), img.ColorIndexAt(0, TileSizePx))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(TileSizePx, TileSizePx))
+
+	img, err = RenderTiles(&Tiles{Data: [][]byte{make([]byte, 64)}, Palette: testPalette})
+	assert.NoError(t, err)
+	tiles, err := ImageToTilesExact(img, testPalette)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{make([]byte, 64)}, tiles.Data)
+	img, err = RenderTiles(&Tiles{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, img.Bounds().Dx())
+}
+
+func TestImageToMetatilesRoundTrip(t *testing.T) {
+	// Solid tiles of colors 0 and 3, a tile with a single pixel of color 1 and its X flip
+	solid0, solid3 := make([]byte, TileSizePx*TileSizePx), bytes.Repeat([]byte{3}, TileSizePx*TileSizePx)
+	dot, flipped := make([]byte, TileSizePx*TileSizePx), make([]byte, TileSizePx*TileSizePx)
+	dot[0], flipped[TileSizePx-1] = 1, 1
+	var encoded bytes.Buffer
+	assert.NoError(t, EncodeTiles(&encoded, &Tiles{Data: [][]byte{solid0, dot, flipped, solid3}}, TileFormatGB))
+	src := NewFSTiles(fstest.MapFS{"a.chr": {Data: encoded.Bytes()}}, TileOptions{Strict: true})
+
+	size := MetatileSize{Width: 3, Height: 1}
+	mtiles := NewMetatiles()
+	mtiles.Palette = testPalette
+	mtiles.Size = size
+	mtiles.Addressing = Addressing8800
+	assert.NoError(t, mtiles.Refs.Insert(TileRef{File: "a.chr", Range: IndexRange{Start: 0, End: 3}}))
+	mtiles.Metatiles = []Metatile{{Tiles: []uint8{0x80, 0x81, 0x82}}, {Tiles: []uint8{0x83, 0x81, 0x80}}}
+	img, err := RenderMetatiles(src, mtiles)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		detectFlips bool
+		tiles       [][]byte
+		metatiles   []Metatile
+	}{
+		{"exact", false, [][]byte{solid0, dot, flipped, solid3}, []Metatile{
+			{Tiles: []uint8{0x80, 0x81, 0x82}},
+			{Tiles: []uint8{0x83, 0x81, 0x80}},
+		}},
+		{"flips", true, [][]byte{solid0, dot, solid3}, []Metatile{
+			{Tiles: []uint8{0x80, 0x81, 0x81}, Attributes: []TileAttributes{0, 0, TileAttributes(FlipX)}},
+			{Tiles: []uint8{0x82, 0x81, 0x80}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tiles, converted, err := ImageToMetatiles(img, testPalette, size, test.detectFlips)
+			assert.NoError(t, err)
+			assert.Equal(t, test.tiles, tiles.Data)
+			assert.Equal(t, size, converted.Size)
+
+			// Indexes start from 0 and are converted to the addressing mode like in the compile command
+			converted.Addressing = Addressing8800
+			for i := range converted.Metatiles {
+				for j, index := range converted.Metatiles[i].Tiles {
+					converted.Metatiles[i].Tiles[j] = converted.Addressing.RefIndex(index)
+				}
+			}
+			assert.Equal(t, test.metatiles, converted.Metatiles)
+		})
+	}
+
+	_, _, err = ImageToMetatiles(image.NewPaletted(image.Rect(0, 0, 4*TileSizePx, TileSizePx), testPalette), testPalette, size, false)
+	assert.Error(t, err)
+	_, _, err = ImageToMetatiles(image.NewPaletted(image.Rect(0, 0, 3*TileSizePx, TileSizePx), testPalette), testPalette, MetatileSize{}, false)
+	assert.Error(t, err)
+}
+
+func TestRenderMap(t *testing.T) {
+	src := NewFSTiles(fstest.MapFS{"a.chr": {Data: testTiles()}}, TileOptions{Strict: true})
+	refs := NewTileRefs()
+	assert.NoError(t, refs.Insert(TileRef{File: "a.chr", Range: IndexRange{Start: 0, End: 3}}))
+
+	// Cells of 8800 maps are converted like metatile indexes, 0x01 is tile 0x81 which has no ref
+	tileMap := NewTileMap()
+	tileMap.Width, tileMap.Height = 3, 1
+	tileMap.Cells = []uint8{0x80, 0x83, 0x01}
+	tileMap.Refs = refs
+	tileMap.Addressing = Addressing8800
+	tileMap.Palette = testPalette
+	img, err := RenderMap(src, tileMap)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*TileSizePx, img.Bounds().Dx())
+	assert.Equal(t, TileSizePx, img.Bounds().Dy())
+	// Index 0 is transparent
+	assert.Equal(t, uint8(1), img.ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(4), img.ColorIndexAt(TileSizePx, 0))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(2*TileSizePx, 0))
+
+	// Attributes with a missing CGB palette fall back to the first one, palettes have 4 colors
+	tileMap.CGBPalettes = [][]color.Color{testPalette, testPalette}
+	tileMap.Attributes = []TileAttributes{5, 1}
+	img, err = RenderMap(src, tileMap)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), img.ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(3+4+1), img.ColorIndexAt(TileSizePx, 0))
+
+	// Cells of metatile maps are metatile indexes, the palette of the metatiles is used if the map has none
+	mtiles := NewMetatiles()
+	mtiles.Size = MetatileSize{Width: 2, Height: 2}
+	mtiles.Refs = refs
+	mtiles.Palette = testPalette
+	mtiles.Metatiles = []Metatile{{Tiles: []uint8{0, 1, 2, 3}}, {Tiles: []uint8{3, 3, 3, 3}}}
+	metatileMap := NewTileMap()
+	metatileMap.Width, metatileMap.Height = 3, 1
+	metatileMap.Cells = []uint8{1, 0, 5}
+	metatileMap.Metatiles = mtiles
+	img, err = RenderMap(src, metatileMap)
+	assert.NoError(t, err)
+	assert.Equal(t, 6*TileSizePx, img.Bounds().Dx())
+	assert.Equal(t, 2*TileSizePx, img.Bounds().Dy())
+	assert.Equal(t, uint8(4), img.ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(1), img.ColorIndexAt(2*TileSizePx, 0))
+	assert.Equal(t, uint8(2), img.ColorIndexAt(3*TileSizePx, 0))
+	assert.Equal(t, uint8(3), img.ColorIndexAt(2*TileSizePx, TileSizePx))
+	assert.Equal(t, uint8(4), img.ColorIndexAt(3*TileSizePx, TileSizePx))
+	// Missing metatiles are transparent
+	assert.Equal(t, uint8(0), img.ColorIndexAt(4*TileSizePx, 0))
+}
+
+func TestTiled(t *testing.T) {
+	mtiles := NewMetatiles()
+	mtiles.Metatiles = []Metatile{
+		{Tiles: []uint8{0, 1, 2, 3}, Properties: []Property{{Name: "collision", Value: "ladder"}}},
+		{Tiles: []uint8{3, 2, 1, 0}, Properties: []Property{{Name: "solid", Type: PropertyBool, Value: "true"}, {Name: "damage", Type: PropertyInt, Value: "2"}}},
+	}
+	var json, tsx bytes.Buffer
+	assert.NoError(t, WriteMetatileJSON(&json, mtiles))
+	assert.NoError(t, WriteMetatileTSX(&tsx, mtiles, "lvl", "lvl.png", "../../lvl.mtile.json"))
+
+	// Cells 2, 1, 0, 2 as base64 encoded zlib compressed data
+	var cells bytes.Buffer
+	w := zlib.NewWriter(&cells)
+	assert.NoError(t, binary.Write(w, binary.LittleEndian, []uint32{2, 1, 0, 2}))
+	assert.NoError(t, w.Close())
+	tmx := `<map width="2" height="2" infinite="0"><tileset firstgid="1" source="tsx/lvl.tsx"/>
+<layer name="fg" width="2" height="2"><data encoding="csv">1,1,1,1</data></layer>
+<layer name="bg" width="2" height="2"><data encoding="base64" compression="zlib">` +
+		base64.StdEncoding.EncodeToString(cells.Bytes()) + `</data></layer></map>`
+	fsys := fstest.MapFS{
+		"lvl.mtile.json":   {Data: json.Bytes()},
+		"maps/tsx/lvl.tsx": {Data: tsx.Bytes()},
+		"maps/lvl.tmx":     {Data: []byte(tmx)},
+	}
+
+	tileMap, err := ReadTMX(fsys, "maps/lvl.tmx", "bg", true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint8{1, 0, 0, 1}, tileMap.Cells)
+		assert.Equal(t, "lvl.mtile.json", tileMap.MetatileFile)
+		assert.Equal(t, mtiles.Metatiles, tileMap.Metatiles.Metatiles)
+	}
+	_, err = ReadTMX(fsys, "maps/lvl.tmx", "objects", true)
+	assert.Error(t, err)
+
+	// Embedded tileset of tiles, flipped tiles get flip attributes
+	tmx = `<map width="3" height="1" infinite="0"><tileset firstgid="5" name="a" tilewidth="8" tileheight="8" tilecount="4" columns="4">
+<properties><property name="tile_data" value="a.chr"/></properties><image source="a.png" width="32" height="8"/></tileset>
+<layer name="bg" width="3" height="1"><data><tile gid="5"/><tile gid="2147483656"/><tile/></data></layer></map>`
+	tileMap, err = ReadTMX(fstest.MapFS{"tiles.tmx": {Data: []byte(tmx)}}, "tiles.tmx", "", true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint8{0, 3, 0}, tileMap.Cells)
+		assert.Equal(t, []TileAttributes{0, TileAttributes(FlipX), 0}, tileMap.Attributes)
+		ref, ok := tileMap.Refs.Find(0, 3)
+		assert.True(t, ok)
+		assert.Equal(t, "a.chr", ref.File)
+	}
+
+	// Metatiles can't be flipped
+	fsys["maps/lvl.tmx"] = &fstest.MapFile{Data: []byte(`<map width="1" height="1"><tileset firstgid="1" source="tsx/lvl.tsx"/>
+<layer name="bg" width="1" height="1"><data encoding="csv">1073741825</data></layer></map>`)}
+	_, err = ReadTMX(fsys, "maps/lvl.tmx", "", true)
+	assert.Error(t, err)
+}
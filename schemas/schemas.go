@@ -0,0 +1,14 @@
+// Package schemas embeds JSON schemas of the config and data files
+package schemas
+
+import "embed"
+
+const (
+	Config    = "config.json"
+	Tiles     = "tiles.json"
+	Metatiles = "metatiles.json"
+	Map       = "map.json"
+)
+
+//go:embed *.json
+var FS embed.FS